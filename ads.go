@@ -24,6 +24,7 @@ import (
 	"github.com/miekg/dns"
 	"golang.org/x/net/context"
 	"strings"
+	"sync/atomic"
 )
 
 var log = clog.NewWithPlugin("ads")
@@ -31,14 +32,26 @@ var log = clog.NewWithPlugin("ads")
 type DNSAdBlock struct {
 	Next              plugin.Handler
 	ConfiguredRuleSet ConfiguredRuleSet
-	FileRuleSet       UpdateableRuleset
-	blacklist         ListMap
-	whitelist         ListMap
-	updater           *ListUpdater
-	config            *adsPluginConfig
+	// updater publishes the lists loaded from HTTP and files
+	updater *ListUpdater
+	config  *adsPluginConfig
+
+	// policy holds the *DNSAdBlock answering queries once a policy file has
+	// been reloaded. Published instances are never modified.
+	policy atomic.Value
+}
+
+// active returns the instance answering queries. It is loaded once per
+// query, so the configuration cannot change while a query is answered.
+func (e *DNSAdBlock) active() *DNSAdBlock {
+	if p, ok := e.policy.Load().(*DNSAdBlock); ok {
+		return p
+	}
+	return e
 }
 
 func (e *DNSAdBlock) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	e = e.active()
 	state := &request.Request{W: w, Req: r}
 
 	trimmedQname := state.Name()
@@ -46,13 +59,20 @@ func (e *DNSAdBlock) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.
 
 	requestCountTotal.WithLabelValues(metrics.WithServer(ctx)).Inc()
 
+	if !e.Ready() {
+		if rcode, handled := e.serveStartup(ctx, w, r, state, trimmedQname); handled {
+			return rcode, nil
+		}
+	}
+
 	if e.ShouldBlock(trimmedQname) {
 		blockedRequestCountTotal.WithLabelValues(metrics.WithServer(ctx)).Inc()
 		blockedRequestCount.WithLabelValues(metrics.WithServer(ctx)).Inc()
-		e.onBlock(w, r, state, trimmedQname)
+		e.onBlock(ctx, w, r, state, trimmedQname)
 		return dns.RcodeSuccess, nil
 	} else {
 		brw := &BlockingResponseWriter{
+			Context:      ctx,
 			Writer:       w,
 			Plugin:       e,
 			Request:      r,
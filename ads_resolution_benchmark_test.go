@@ -40,7 +40,7 @@ func BenchmarkBlockSpeed(b *testing.B) {
 		tcase := test.Case{
 			Qname: fmt.Sprintf("testhost-%09d.local.test.tld", i+1), Qtype: dns.TypeA,
 			Answer: []dns.RR{
-				test.A(fmt.Sprintf("testhost-%09d.local.test.tld. 3600	IN	A 10.1.33.7", i+1)),
+				test.A(fmt.Sprintf("testhost-%09d.local.test.tld. 60	IN	A 10.1.33.7", i+1)),
 			},
 		}
 		testCases = append(testCases, tcase)
@@ -72,10 +72,9 @@ func initBenchPlugin(t testing.TB) *DNSAdBlock {
 	cfg.TargetIP = net.ParseIP("10.1.33.7")
 
 	p := DNSAdBlock{
-		Next:      nxDomainHandler(),
-		blacklist: blockmap,
-		updater:   nil,
-		config:    &cfg,
+		Next:    nxDomainHandler(),
+		updater: testListUpdater(UpdateableRuleset{Blacklist: blockmap}),
+		config:  &cfg,
 	}
 
 	return &p
@@ -28,11 +28,101 @@ import (
 )
 
 func TestLookup_CNAME_Blocked_Root(t *testing.T) {
-	// TODO IMPLEMENT ME
+	p := initTestPlugin(t, BuildRuleset(make([]string, 0), []string{"tracker.test.tld"}))
+	p.Next = upstreamHandler(map[string][]string{
+		"www.test.tld.": {
+			"www.test.tld. 3600 IN CNAME cdn.test.tld.",
+			"cdn.test.tld. 3600 IN CNAME tracker.test.tld.",
+			"tracker.test.tld. 3600 IN A 203.0.113.10",
+		},
+	})
+
+	testCases := []test.Case{
+		{
+			Qname: "www.test.tld", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.test.tld. 60 IN A 10.1.33.7")},
+		},
+	}
+	resolveTestCases(testCases, p, context.TODO(), t)
 }
 
 func TestLookup_CNAME_Blocked_CNAME(t *testing.T) {
-	// TODO IMPLEMENT ME
+	p := initTestPlugin(t, BuildRuleset(make([]string, 0), []string{"cdn.test.tld"}))
+	p.Next = upstreamHandler(map[string][]string{
+		"www.test.tld.": {
+			"www.test.tld. 3600 IN CNAME cdn.test.tld.",
+			"cdn.test.tld. 3600 IN CNAME edge.test.tld.",
+			"edge.test.tld. 3600 IN A 203.0.113.10",
+		},
+		"other.test.tld.": {
+			"other.test.tld. 3600 IN CNAME edge.test.tld.",
+			"edge.test.tld. 3600 IN A 203.0.113.10",
+		},
+	})
+
+	testCases := []test.Case{
+		{
+			Qname: "www.test.tld", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.test.tld. 60 IN A 10.1.33.7")},
+		},
+		{
+			Qname: "other.test.tld", Qtype: dns.TypeA,
+			Answer: []dns.RR{
+				test.A("edge.test.tld. 3600 IN A 203.0.113.10"),
+				test.CNAME("other.test.tld. 3600 IN CNAME edge.test.tld."),
+			},
+		},
+	}
+	resolveTestCases(testCases, p, context.TODO(), t)
+}
+
+func TestLookup_Inspection(t *testing.T) {
+	records := map[string][]string{
+		"dname.test.tld.":  {"test.tld. 3600 IN DNAME tracker.test.", "dname.test.tld. 3600 IN CNAME dname.tracker.test."},
+		"svcb.test.tld.":   {"svcb.test.tld. 3600 IN SVCB 1 tracker.test. alpn=h2"},
+		"https.test.tld.":  {"https.test.tld. 3600 IN HTTPS 1 tracker.test. alpn=h2"},
+		"alias.test.tld.":  {"alias.test.tld. 3600 IN HTTPS 1 . alpn=h2"},
+		"mx.test.tld.":     {"mx.test.tld. 3600 IN MX 10 tracker.test."},
+		"srv.test.tld.":    {"srv.test.tld. 3600 IN SRV 10 10 443 tracker.test."},
+		"owner.test.tld.":  {"owner.test.tld. 3600 IN CNAME Tracker.Test.", "tracker.test. 3600 IN CNAME edge.test.tld."},
+		"permit.test.tld.": {"permit.test.tld. 3600 IN MX 10 permitted.tracker.test."},
+	}
+	blocked := map[string]uint16{
+		"dname.test.tld": dns.TypeDNAME,
+		"svcb.test.tld":  dns.TypeSVCB,
+		"https.test.tld": dns.TypeHTTPS,
+		"mx.test.tld":    dns.TypeMX,
+		"srv.test.tld":   dns.TypeSRV,
+		"owner.test.tld": dns.TypeCNAME,
+	}
+
+	ruleset := BuildRuleset([]string{"permitted.tracker.test"}, []string{"tracker.test", "permitted.tracker.test"})
+	p := initTestPlugin(t, ruleset)
+	p.Next = upstreamHandler(records)
+
+	for qname, rrtype := range blocked {
+		assert.True(t, resolvesToTarget(t, p, qname), "expected %s to be blocked", qname)
+
+		p.config.DisabledInspections = map[uint16]bool{rrtype: true}
+		assert.False(t, resolvesToTarget(t, p, qname), "expected %s to be allowed", qname)
+		p.config.DisabledInspections = nil
+	}
+
+	assert.False(t, resolvesToTarget(t, p, "alias.test.tld"))
+	assert.False(t, resolvesToTarget(t, p, "permit.test.tld"))
+}
+
+// resolvesToTarget returns true if the query has been answered with the
+// blocking response.
+func resolvesToTarget(t *testing.T, p *DNSAdBlock, qname string) bool {
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	_, err := p.ServeDNS(context.TODO(), rec, test.Case{Qname: qname, Qtype: dns.TypeA}.Msg())
+	assert.NoError(t, err)
+	if rec.Msg == nil || len(rec.Msg.Answer) != 1 {
+		return false
+	}
+	a, ok := rec.Msg.Answer[0].(*dns.A)
+	return ok && a.A.Equal(p.config.TargetIP)
 }
 
 func TestLookup_Block_IPv6(t *testing.T) {
@@ -47,7 +137,7 @@ func TestLookup_Block_IPv6(t *testing.T) {
 			Qname: qname,
 			Qtype: dns.TypeAAAA,
 			Answer: []dns.RR{
-				test.AAAA(fmt.Sprintf("%s. 3600	IN	AAAA fe80::9cbd:c3ff:fe28:e133", qname)),
+				test.AAAA(fmt.Sprintf("%s. 60	IN	AAAA fe80::9cbd:c3ff:fe28:e133", qname)),
 			},
 		}
 		testCases = append(testCases, tcase)
@@ -74,7 +164,7 @@ func TestLookup_RegexBlacklist(t *testing.T) {
 			Qname: qname,
 			Qtype: dns.TypeA,
 			Answer: []dns.RR{
-				test.A(fmt.Sprintf("%s. 3600	IN	A 10.1.33.7", qname)),
+				test.A(fmt.Sprintf("%s. 60	IN	A 10.1.33.7", qname)),
 			},
 		}
 		testCases = append(testCases, tcase)
@@ -155,7 +245,7 @@ func TestLookup_Blacklist(t *testing.T) {
 			Qname: qname,
 			Qtype: dns.TypeA,
 			Answer: []dns.RR{
-				test.A(fmt.Sprintf("%s. 3600	IN	A 10.1.33.7", qname)),
+				test.A(fmt.Sprintf("%s. 60	IN	A 10.1.33.7", qname)),
 			},
 		}
 		testCases = append(testCases, tcase)
@@ -233,7 +323,7 @@ func initBlockedTestCases() []test.Case {
 		tcase := test.Case{
 			Qname: fmt.Sprintf("testhost-%09d.local.test.tld", i+1), Qtype: dns.TypeA,
 			Answer: []dns.RR{
-				test.A(fmt.Sprintf("testhost-%09d.local.test.tld. 3600	IN	A 10.1.33.7", i+1)),
+				test.A(fmt.Sprintf("testhost-%09d.local.test.tld. 60	IN	A 10.1.33.7", i+1)),
 			},
 		}
 		testCases = append(testCases, tcase)
@@ -254,15 +344,23 @@ func initTestPlugin(t testing.TB, rs ConfiguredRuleSet) *DNSAdBlock {
 
 	p := DNSAdBlock{
 		Next:              nxDomainHandler(),
-		blacklist:         blockmap,
 		ConfiguredRuleSet: rs,
-		updater:           nil,
+		updater:           testListUpdater(UpdateableRuleset{Blacklist: blockmap}),
 		config:            &cfg,
 	}
 
 	return &p
 }
 
+// testListUpdater returns an updater which has loaded the given lists.
+func testListUpdater(lists UpdateableRuleset) *ListUpdater {
+	u := &ListUpdater{loaded: 1}
+	u.publish(func(s *listSnapshot) {
+		s.http = lists
+	})
+	return u
+}
+
 func getEmptyRuleset() ConfiguredRuleSet {
 	return BuildRuleset(make([]string, 0), make([]string, 0))
 }
@@ -275,3 +373,95 @@ func nxDomainHandler() test.Handler {
 		return dns.RcodeNameError, nil
 	})
 }
+
+// upstreamHandler answers queries with the records defined for the qname,
+// all other queries are answered with NXDOMAIN.
+func upstreamHandler(records map[string][]string) test.Handler {
+	return test.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		m := new(dns.Msg)
+		rrs, ok := records[r.Question[0].Name]
+		if !ok {
+			m.SetRcode(r, dns.RcodeNameError)
+			w.WriteMsg(m)
+			return dns.RcodeNameError, nil
+		}
+		m.SetReply(r)
+		for _, v := range rrs {
+			rr, err := dns.NewRR(v)
+			if err != nil {
+				return dns.RcodeServerFailure, err
+			}
+			m.Answer = append(m.Answer, rr)
+		}
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	})
+}
+
+func TestLookup_BlockPage(t *testing.T) {
+	p := initTestPlugin(t, getEmptyRuleset())
+	p.config.BlockPageHost = "blocked.corp.internal."
+	p.Next = upstreamHandler(map[string][]string{
+		"blocked.corp.internal.": {"blocked.corp.internal. 300 IN A 10.0.0.80"},
+		"www.test.tld.": {
+			"www.test.tld. 3600 IN CNAME testhost-000000002.local.test.tld.",
+			"testhost-000000002.local.test.tld. 3600 IN A 203.0.113.10",
+		},
+	})
+
+	testCases := []test.Case{
+		{
+			Qname: "testhost-000000001.local.test.tld", Qtype: dns.TypeA,
+			Answer: []dns.RR{
+				test.A("blocked.corp.internal. 60 IN A 10.0.0.80"),
+				test.CNAME("testhost-000000001.local.test.tld. 60 IN CNAME blocked.corp.internal."),
+			},
+		},
+		{
+			Qname: "www.test.tld", Qtype: dns.TypeA,
+			Answer: []dns.RR{
+				test.A("blocked.corp.internal. 60 IN A 10.0.0.80"),
+				test.CNAME("www.test.tld. 60 IN CNAME blocked.corp.internal."),
+			},
+		},
+	}
+	resolveTestCases(testCases, p, context.TODO(), t)
+
+	// The CNAME is returned on its own if the block page cannot be resolved
+	p.Next = nxDomainHandler()
+	testCases = []test.Case{
+		{
+			Qname: "testhost-000000001.local.test.tld", Qtype: dns.TypeAAAA,
+			Answer: []dns.RR{
+				test.CNAME("testhost-000000001.local.test.tld. 60 IN CNAME blocked.corp.internal."),
+			},
+		},
+	}
+	resolveTestCases(testCases, p, context.TODO(), t)
+}
+
+func TestLookup_TTL(t *testing.T) {
+	p := initTestPlugin(t, getEmptyRuleset())
+	p.config.TargetTTL = 5
+
+	qname := "testhost-000000001.local.test.tld"
+	testCases := []test.Case{
+		{Qname: qname, Qtype: dns.TypeA, Answer: []dns.RR{test.A(qname + ". 5 IN A 10.1.33.7")}},
+	}
+	resolveTestCases(testCases, p, context.TODO(), t)
+
+	p.config.WriteNXDomain = true
+
+	// The negative TTL applies to the SOA of the authority section
+	for _, ttl := range []uint32{10, 0} {
+		p.config.NXDomainTTL = ttl
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		_, err := p.ServeDNS(context.TODO(), rec, test.Case{Qname: qname, Qtype: dns.TypeA}.Msg())
+		assert.NoError(t, err)
+		assert.Equal(t, dns.RcodeNameError, rec.Msg.Rcode)
+		if assert.Len(t, rec.Msg.Ns, 1) {
+			assert.Equal(t, ttl, rec.Msg.Ns[0].Header().Ttl)
+			assert.Equal(t, ttl, rec.Msg.Ns[0].(*dns.SOA).Minttl)
+		}
+	}
+}
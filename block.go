@@ -17,40 +17,135 @@
 package ads
 
 import (
+	"context"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/coredns/coredns/plugin/pkg/nonwriter"
 	"github.com/coredns/coredns/request"
 	"github.com/miekg/dns"
 	"net"
+	"strings"
 )
 
 func (e *DNSAdBlock) IsWhitelisted(qname string) bool {
-	return e.whitelist[qname] || e.ConfiguredRuleSet.IsWhitelisted(qname) || e.FileRuleSet.IsWhitelisted(qname)
+	e = e.active()
+	lists := e.updater.lists()
+	return lists.http.IsWhitelisted(qname) || e.ConfiguredRuleSet.IsWhitelisted(qname) || lists.files.IsWhitelisted(qname)
 }
 
 func (e *DNSAdBlock) IsBlacklisted(qname string) bool {
-	return e.blacklist[qname] || e.ConfiguredRuleSet.IsBlacklisted(qname) || e.FileRuleSet.IsBlacklisted(qname)
+	e = e.active()
+	lists := e.updater.lists()
+	return lists.http.IsBlacklisted(qname) || e.ConfiguredRuleSet.IsBlacklisted(qname) || lists.files.IsBlacklisted(qname)
 }
 
 func (e *DNSAdBlock) ShouldBlock(qname string) bool {
+	e = e.active()
 	return !e.IsWhitelisted(qname) && e.IsBlacklisted(qname)
 }
 
-func (e *DNSAdBlock) onBlock(w dns.ResponseWriter, r *dns.Msg, state *request.Request, trimmedQname string) error {
-	var answers []dns.RR
-	if e.config.WriteNXDomain {
-		answers = nxdomain(state.Name())
-	} else if state.QType() == dns.TypeAAAA {
-		answers = aaaa(state.Name(), []net.IP{e.config.TargetIPv6})
-	} else {
-		answers = a(state.Name(), []net.IP{e.config.TargetIP})
+func (e *DNSAdBlock) IsNetworkBlacklisted(ip net.IP) bool {
+	e = e.active()
+	lists := e.updater.lists()
+	return lists.http.IsNetworkBlacklisted(ip) || e.ConfiguredRuleSet.IsNetworkBlacklisted(ip) || lists.files.IsNetworkBlacklisted(ip)
+}
+
+// blacklistSources returns the origin of all blacklist entries for qname.
+func (e *DNSAdBlock) blacklistSources(qname string) []string {
+	e = e.active()
+	sources := make([]string, 0)
+	if e.ConfiguredRuleSet.IsBlacklisted(qname) {
+		sources = append(sources, "Corefile")
 	}
+	if e.updater != nil {
+		sources = append(sources, e.updater.Provenance(qname, listKindBlacklist)...)
+	}
+	return sources
+}
+
+func (e *DNSAdBlock) onBlock(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, state *request.Request, trimmedQname string) error {
+	action := e.blockAction(trimmedQname)
 
 	m := new(dns.Msg)
 	m.SetReply(r)
 	m.Authoritative, m.RecursionAvailable = true, true
+
+	var answers []dns.RR
+	switch {
+	case action.Mode == responseModeBlockPage:
+		answers = e.blockPage(ctx, state, action.BlockPageHost)
+	case action.Mode == responseModeNXDomain:
+		// The SOA has to be part of the authority section, so resolvers
+		// cache the response according to RFC 2308
+		m.Rcode = dns.RcodeNameError
+		m.Ns = nxdomain(state.Name(), e.config.NXDomainTTL)
+	case state.QType() == dns.TypeAAAA:
+		ip := action.TargetIPv6
+		if ip == nil {
+			// Actions without IPv6 target use the global one
+			ip = e.config.TargetIPv6
+		}
+		answers = aaaa(state.Name(), []net.IP{ip}, e.config.TargetTTL)
+	default:
+		answers = a(state.Name(), []net.IP{action.TargetIP}, e.config.TargetTTL)
+	}
 	m.Answer = answers
 
+	categories := e.blockCategories(trimmedQname)
+	if len(categories) == 0 {
+		blockedCategoryCount.WithLabelValues(metrics.WithServer(ctx), uncategorized).Inc()
+	}
+	for _, category := range categories {
+		blockedCategoryCount.WithLabelValues(metrics.WithServer(ctx), category).Inc()
+	}
+
 	if e.config.EnableLogging {
-		log.Infof("Blocked request %q from %q", trimmedQname, state.IP())
+		details := make([]string, 0, 2)
+		if sources := e.blacklistSources(trimmedQname); len(sources) > 0 {
+			details = append(details, "lists: "+strings.Join(sources, ", "))
+		}
+		if len(categories) > 0 {
+			details = append(details, "categories: "+strings.Join(categories, ", "))
+		}
+		if len(details) > 0 {
+			log.Infof("Blocked request %q from %q (%s)", trimmedQname, state.IP(), strings.Join(details, "; "))
+		} else {
+			log.Infof("Blocked request %q from %q", trimmedQname, state.IP())
+		}
 	}
 	return w.WriteMsg(m)
 }
+
+// blockPage answers with a CNAME to the given block page host. The host is
+// resolved by the following plugins, so its address may change at any time.
+// If the resolution fails, the CNAME is returned on its own. The TTL of the
+// resolved records is capped, so the block is not cached longer than the
+// CNAME.
+func (e *DNSAdBlock) blockPage(ctx context.Context, state *request.Request, host string) []dns.RR {
+	ttl := e.config.BlockPageTTL
+	answers := cname(state.Name(), host, ttl)
+	if state.QType() == dns.TypeCNAME {
+		return answers
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(host), state.QType())
+	m.RecursionDesired = true
+
+	nw := nonwriter.New(state.W)
+	if _, err := plugin.NextOrFailure(e.Name(), e.Next, ctx, nw, m); err != nil {
+		log.Warningf("Resolving block page %q has failed: %s", host, err.Error())
+		return answers
+	}
+	if nw.Msg == nil || nw.Msg.Rcode != dns.RcodeSuccess {
+		log.Warningf("Resolving block page %q has failed", host)
+		return answers
+	}
+	for _, rr := range nw.Msg.Answer {
+		if rr.Header().Ttl > ttl {
+			rr.Header().Ttl = ttl
+		}
+		answers = append(answers, rr)
+	}
+	return answers
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"net"
+	"strings"
+
+	"github.com/coredns/caddy"
+	"github.com/miekg/dns"
+)
+
+// Response modes of blocked requests
+const (
+	responseModeTarget    = "target"
+	responseModeNXDomain  = "nxdomain"
+	responseModeBlockPage = "block-page"
+)
+
+// blockAction defines how blocked requests are answered. It is either
+// derived from the global options or configured for a single source.
+type blockAction struct {
+	Mode          string
+	TargetIP      net.IP
+	TargetIPv6    net.IP
+	BlockPageHost string
+}
+
+// defaultBlockAction returns the action configured by the global options.
+func (cfg *adsPluginConfig) defaultBlockAction() *blockAction {
+	action := &blockAction{Mode: responseModeTarget, TargetIP: cfg.TargetIP, TargetIPv6: cfg.TargetIPv6}
+	if cfg.BlockPageHost != "" {
+		action.Mode, action.BlockPageHost = responseModeBlockPage, cfg.BlockPageHost
+	} else if cfg.WriteNXDomain {
+		action.Mode = responseModeNXDomain
+	}
+	return action
+}
+
+// parseBlockAction parses the arguments of an `action` option:
+// `nxdomain`, `target <IPv4> [<IPv6>]` or `block-page <HOST>`.
+func parseBlockAction(c *caddy.Controller) (*blockAction, error) {
+	args := c.RemainingArgs()
+	if len(args) == 0 {
+		return nil, c.Err("No action defined, expected nxdomain, target or block-page")
+	}
+
+	action := &blockAction{Mode: args[0]}
+	switch args[0] {
+	case responseModeNXDomain:
+		if len(args) != 1 {
+			return nil, c.Err("The nxdomain action has no arguments")
+		}
+	case responseModeTarget:
+		if len(args) < 2 || len(args) > 3 {
+			return nil, c.Err("The target action has to be defined as 'action target <IPv4> [<IPv6>]'")
+		}
+		action.TargetIP = net.ParseIP(args[1])
+		if action.TargetIP == nil || action.TargetIP.To4() == nil {
+			return nil, c.Errf("Invalid IPv4 address %q", args[1])
+		}
+		if len(args) == 3 {
+			action.TargetIPv6 = net.ParseIP(args[2])
+			if action.TargetIPv6 == nil || action.TargetIPv6.To4() != nil {
+				return nil, c.Errf("Invalid IPv6 address %q", args[2])
+			}
+		}
+	case responseModeBlockPage:
+		if len(args) != 2 {
+			return nil, c.Err("The block-page action has to be defined as 'action block-page <HOST>'")
+		}
+		if _, ok := dns.IsDomainName(args[1]); !ok {
+			return nil, c.Errf("Invalid block page host %q", args[1])
+		}
+		action.BlockPageHost = dns.Fqdn(strings.ToLower(args[1]))
+	default:
+		return nil, c.Errf("Unknown action %q, expected nxdomain, target or block-page", args[0])
+	}
+	return action, nil
+}
+
+// parseRuleAction parses the optional block following a `block` or
+// `block-regex` rule, which may only contain an action.
+func parseRuleAction(c *caddy.Controller) (*blockAction, error) {
+	var action *blockAction
+	err := parseBlock(c, func() error {
+		if c.Val() != "action" {
+			return c.Errf("Unknown rule option %q", c.Val())
+		}
+		var err error
+		action, err = parseBlockAction(c)
+		return err
+	})
+	return action, err
+}
+
+// blockAction determines the action for a blocked name. Rules defined in
+// the Corefile take precedence over lists, exact rules over regex rules and
+// lists defined earlier over lists defined later. The first of these sources
+// defining an action is used, otherwise the global options apply.
+func (e *DNSAdBlock) blockAction(qname string) *blockAction {
+	if action := e.ConfiguredRuleSet.BlacklistActions[qname]; action != nil {
+		return action
+	}
+	for _, exp := range e.ConfiguredRuleSet.BlacklistRegex {
+		if action := e.ConfiguredRuleSet.BlacklistRegexActions[exp.String()]; action != nil && exp.MatchString(qname) {
+			return action
+		}
+	}
+
+	if e.updater != nil {
+		var selected *listSourceConfig
+		for _, key := range e.updater.matchingSources(qname, listKindBlacklist) {
+			source := e.config.ListSources[key]
+			if source == nil || source.Action == nil {
+				continue
+			}
+			if selected == nil || source.Order < selected.Order {
+				selected = source
+			}
+		}
+		if selected != nil {
+			return selected.Action
+		}
+	}
+	return e.config.defaultBlockAction()
+}
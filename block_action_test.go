@@ -0,0 +1,96 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockAction_Precedence(t *testing.T) {
+	ruleset := BuildRuleset(make([]string, 0), []string{"rule.example.com"})
+	assert.NoError(t, ruleset.AddRegexToBlacklist(`^regex\.`))
+	ruleAction := &blockAction{Mode: responseModeTarget, TargetIP: net.ParseIP("10.0.0.1")}
+	regexAction := &blockAction{Mode: responseModeTarget, TargetIP: net.ParseIP("10.0.0.2")}
+	ruleset.BlacklistActions["rule.example.com"] = ruleAction
+	ruleset.BlacklistRegexActions[`^regex\.`] = regexAction
+
+	p := initTestPlugin(t, ruleset)
+	malware := &listSourceConfig{URL: "https://example.com/malware.txt", Order: 0, Action: &blockAction{Mode: responseModeNXDomain}}
+	plain := &listSourceConfig{URL: "https://example.com/plain.txt", Order: 1}
+	ads := &listSourceConfig{URL: "https://example.com/ads.txt", Order: 2, Action: &blockAction{Mode: responseModeTarget, TargetIP: net.IPv4zero}}
+	p.config.ListSources = map[string]*listSourceConfig{malware.URL: malware, plain.URL: plain, ads.URL: ads}
+
+	p.updater = &ListUpdater{Plugin: p}
+	p.updater.sources = []*listSourceState{
+		{URL: ads.URL, Kind: listKindBlacklist, list: ListMap{"both.example.com": true, "ad.example.com": true, "rule.example.com": true, "regex.example.com": true}},
+		{URL: plain.URL, Kind: listKindBlacklist, list: ListMap{"plain.example.com": true, "ad.example.com": true}},
+		{URL: malware.URL, Kind: listKindBlacklist, list: ListMap{"both.example.com": true}},
+	}
+
+	assert.Equal(t, ruleAction, p.blockAction("rule.example.com"))
+	assert.Equal(t, regexAction, p.blockAction("regex.example.com"))
+	assert.Equal(t, malware.Action, p.blockAction("both.example.com"))
+	assert.Equal(t, ads.Action, p.blockAction("ad.example.com"))
+	assert.Equal(t, p.config.defaultBlockAction(), p.blockAction("plain.example.com"))
+}
+
+func TestLookup_BlockAction(t *testing.T) {
+	ruleset := BuildRuleset(make([]string, 0), []string{"malware.example.com", "ads.example.com"})
+	ruleset.BlacklistActions["malware.example.com"] = &blockAction{Mode: responseModeNXDomain}
+	ruleset.BlacklistActions["ads.example.com"] = &blockAction{Mode: responseModeTarget, TargetIP: net.IPv4zero}
+	p := initTestPlugin(t, ruleset)
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	_, err := p.ServeDNS(context.TODO(), rec, test.Case{Qname: "malware.example.com", Qtype: dns.TypeA}.Msg())
+	assert.NoError(t, err)
+	assert.Equal(t, dns.RcodeNameError, rec.Msg.Rcode)
+	assert.Empty(t, rec.Msg.Answer)
+	if assert.Len(t, rec.Msg.Ns, 1) {
+		assert.Equal(t, dns.TypeSOA, rec.Msg.Ns[0].Header().Rrtype)
+	}
+
+	testCases := []test.Case{
+		{Qname: "ads.example.com", Qtype: dns.TypeA, Answer: []dns.RR{test.A("ads.example.com. 60 IN A 0.0.0.0")}},
+		// Without IPv6 target the global one is used
+		{Qname: "ads.example.com", Qtype: dns.TypeAAAA, Answer: []dns.RR{test.AAAA("ads.example.com. 60 IN AAAA fe80::9cbd:c3ff:fe28:e133")}},
+		{Qname: "testhost-000000001.local.test.tld", Qtype: dns.TypeA, Answer: []dns.RR{test.A("testhost-000000001.local.test.tld. 60 IN A 10.1.33.7")}},
+	}
+	resolveTestCases(testCases, p, context.TODO(), t)
+}
+
+func TestLookup_SourceAction(t *testing.T) {
+	p := initTestPlugin(t, getEmptyRuleset())
+	malware := &listSourceConfig{URL: "https://example.com/malware.txt", Action: &blockAction{Mode: responseModeNXDomain}}
+	p.config.ListSources = map[string]*listSourceConfig{malware.URL: malware}
+	p.updater = testListUpdater(UpdateableRuleset{Blacklist: ListMap{"malware.example.com": true}})
+	p.updater.sources = []*listSourceState{
+		{URL: malware.URL, Kind: listKindBlacklist, list: ListMap{"malware.example.com": true}},
+	}
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	_, err := p.ServeDNS(context.TODO(), rec, test.Case{Qname: "malware.example.com", Qtype: dns.TypeA}.Msg())
+	assert.NoError(t, err)
+	assert.Equal(t, dns.RcodeNameError, rec.Msg.Rcode)
+	assert.Empty(t, rec.Msg.Answer)
+}
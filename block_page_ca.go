@@ -0,0 +1,216 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// The CA can issue certificates for any name, so its lifetime is kept
+	// short. An expired CA has to be replaced by the admin.
+	blockPageCAValidity          = 365 * 24 * time.Hour
+	blockPageCertificateValidity = 90 * 24 * time.Hour
+	// blockPageCertificateCacheSize limits the number of cached certificates,
+	// the cache gets cleared once it is exceeded
+	blockPageCertificateCacheSize = 4096
+)
+
+// blockPageCA issues certificates for blocked names on the fly. Clients
+// trusting the CA see the block page instead of a certificate error.
+type blockPageCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+
+	// All issued certificates share one key
+	leafKey *ecdsa.PrivateKey
+
+	mutex sync.Mutex
+	cache map[string]*tls.Certificate
+}
+
+// loadOrCreateBlockPageCA loads the CA from the given files. If neither of
+// them exists, a new CA is generated and written to the files, so the admin
+// can distribute the certificate to the clients.
+func loadOrCreateBlockPageCA(certFile, keyFile string) (*blockPageCA, error) {
+	certExists, keyExists := exists(certFile), exists(keyFile)
+	if certExists != keyExists {
+		return nil, fmt.Errorf("either both or none of the CA files %q and %q have to exist", certFile, keyFile)
+	}
+
+	var ca *blockPageCA
+	var err error
+	if certExists {
+		ca, err = loadBlockPageCA(certFile, keyFile)
+	} else {
+		ca, err = createBlockPageCA(certFile, keyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ca.leafKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	ca.cache = make(map[string]*tls.Certificate)
+	return ca, nil
+}
+
+func loadBlockPageCA(certFile, keyFile string) (*blockPageCA, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok || !cert.IsCA {
+		return nil, fmt.Errorf("%q is not an ECDSA CA certificate", certFile)
+	}
+	if time.Now().After(cert.NotAfter) {
+		return nil, fmt.Errorf("the CA %q has expired, remove %q and %q to generate a new one", certFile, certFile, keyFile)
+	}
+	return &blockPageCA{cert: cert, key: key}, nil
+}
+
+func createBlockPageCA(certFile, keyFile string) (*blockPageCA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          randomSerialNumber(),
+		Subject:               pkix.Name{CommonName: "CoreDNS ads block page CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(blockPageCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return nil, err
+	}
+	log.Infof("Generated block page CA %q, clients have to trust it to see the block page using HTTPS", certFile)
+
+	return &blockPageCA{cert: cert, key: key}, nil
+}
+
+// certificate returns a certificate for the requested server name. Clients
+// connecting without SNI get a certificate for the address they connected to.
+func (ca *blockPageCA) certificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := hello.ServerName
+	if name == "" && hello.Conn != nil {
+		if host, _, err := net.SplitHostPort(hello.Conn.LocalAddr().String()); err == nil {
+			name = host
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("no server name requested")
+	}
+
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	if cert, ok := ca.cache[name]; ok && time.Now().Before(cert.Leaf.NotAfter.Add(-time.Hour)) {
+		return cert, nil
+	}
+	if len(ca.cache) >= blockPageCertificateCacheSize {
+		ca.cache = make(map[string]*tls.Certificate)
+	}
+
+	cert, err := ca.issue(name)
+	if err != nil {
+		return nil, err
+	}
+	ca.cache[name] = cert
+	return cert, nil
+}
+
+func (ca *blockPageCA) issue(name string) (*tls.Certificate, error) {
+	notAfter := time.Now().Add(blockPageCertificateValidity)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: randomSerialNumber(),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{name}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &ca.leafKey.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  ca.leafKey,
+		Leaf:        leaf,
+	}, nil
+}
+
+func randomSerialNumber() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+	return serial
+}
@@ -0,0 +1,317 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/miekg/dns"
+)
+
+const blockPageShutdownTimeout = 5 * time.Second
+
+const defaultBlockPageTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Host}} has been blocked</title>
+</head>
+<body>
+<h1>{{.Host}} has been blocked</h1>
+<p>Access to this site has been blocked by your DNS server.</p>
+{{if .Lists}}<p>The site is listed in:</p>
+<ul>
+{{range .Lists}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}{{if .UnblockURL}}<p><a href="{{.UnblockURL}}">Request unblocking of {{.Host}}</a></p>
+{{end}}</body>
+</html>
+`
+
+// blockPageServerConfig holds the options of the built-in block page server.
+type blockPageServerConfig struct {
+	HTTPAddrs    []string
+	HTTPSAddrs   []string
+	TemplateFile string
+	UnblockURL   *url.URL
+	CACertFile   string
+	CAKeyFile    string
+}
+
+// blockPageData is passed to the block page template.
+type blockPageData struct {
+	Host       string
+	Lists      []string
+	UnblockURL string
+}
+
+// BlockPageServer serves a page explaining why a site has been blocked on
+// the target addresses blocked names resolve to.
+type BlockPageServer struct {
+	Plugin *DNSAdBlock
+
+	config   *blockPageServerConfig
+	template *template.Template
+	ca       *blockPageCA
+
+	mutex   sync.Mutex
+	servers []*http.Server
+}
+
+func newBlockPageServer(cfg *blockPageServerConfig) (*BlockPageServer, error) {
+	s := &BlockPageServer{config: cfg}
+
+	tmpl := defaultBlockPageTemplate
+	if cfg.TemplateFile != "" {
+		data, err := ioutil.ReadFile(cfg.TemplateFile)
+		if err != nil {
+			return nil, err
+		}
+		tmpl = string(data)
+	}
+	t, err := template.New("block-page").Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	s.template = t
+
+	if cfg.CACertFile != "" {
+		ca, err := loadOrCreateBlockPageCA(cfg.CACertFile, cfg.CAKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		s.ca = ca
+	}
+	return s, nil
+}
+
+// Start opens all listeners. Listeners that have been opened already get
+// closed if one of them fails.
+func (s *BlockPageServer) Start() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	listeners := make([]net.Listener, 0)
+	servers := make([]*http.Server, 0)
+	listen := func(addrs []string, tlsConfig *tls.Config) error {
+		for _, addr := range addrs {
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				return err
+			}
+			if tlsConfig != nil {
+				ln = tls.NewListener(ln, tlsConfig)
+			}
+			listeners = append(listeners, ln)
+			servers = append(servers, &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second})
+		}
+		return nil
+	}
+
+	err := listen(s.config.HTTPAddrs, nil)
+	if err == nil && s.ca != nil {
+		err = listen(s.config.HTTPSAddrs, &tls.Config{GetCertificate: s.certificate})
+	}
+	if err != nil {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+		return err
+	}
+
+	for i, srv := range servers {
+		go func(srv *http.Server, ln net.Listener) {
+			if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+				log.Errorf("Block page server on %s failed: %s", ln.Addr().String(), err.Error())
+			}
+		}(srv, listeners[i])
+		log.Infof("Serving block page on %s", listeners[i].Addr().String())
+	}
+	s.servers = servers
+	return nil
+}
+
+// Stop closes all listeners, running requests get some time to complete.
+func (s *BlockPageServer) Stop() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), blockPageShutdownTimeout)
+	defer cancel()
+	for _, srv := range s.servers {
+		srv.Shutdown(ctx)
+	}
+	s.servers = nil
+	return nil
+}
+
+// certificate issues certificates for blocked names and block page hosts
+// only. Clients trust the CA for every name, so anyone reaching the server
+// could get a valid certificate for any name otherwise.
+func (s *BlockPageServer) certificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if hello.ServerName != "" && !s.isBlocked(hello.ServerName) {
+		return nil, fmt.Errorf("refusing to issue a certificate for %q, the name is not blocked", hello.ServerName)
+	}
+	return s.ca.certificate(hello)
+}
+
+func (s *BlockPageServer) isBlocked(name string) bool {
+	if s.Plugin == nil {
+		return false
+	}
+	e := s.Plugin.active()
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	return e.ShouldBlock(name) || e.config.isBlockPageHost(name)
+}
+
+func (s *BlockPageServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if r.TLS != nil && r.TLS.ServerName != "" {
+		host = r.TLS.ServerName
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	data := blockPageData{Host: host, Lists: make([]string, 0)}
+	if s.Plugin != nil {
+		data.Lists = s.Plugin.blacklistSources(host)
+	}
+	if s.config.UnblockURL != nil {
+		u := *s.config.UnblockURL
+		query := u.Query()
+		query.Set("host", host)
+		u.RawQuery = query.Encode()
+		data.UnblockURL = u.String()
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	// Blocked content is unavailable for legal or policy reasons
+	w.WriteHeader(http.StatusUnavailableForLegalReasons)
+	if err := s.template.Execute(w, data); err != nil {
+		log.Errorf("Rendering the block page failed: %s", err.Error())
+	}
+}
+
+// parseBlockPageServerOption parses a single option of the
+// block-page-server block.
+func parseBlockPageServerOption(c *caddy.Controller, cfg *blockPageServerConfig) error {
+	switch c.Val() {
+	case "http", "https":
+		option := c.Val()
+		args := c.RemainingArgs()
+		if len(args) == 0 {
+			return c.Errf("No listen address for %s defined", option)
+		}
+		for _, v := range args {
+			if _, _, err := net.SplitHostPort(v); err != nil {
+				return c.Errf("Invalid listen address %q, expected <HOST>:<PORT>", v)
+			}
+		}
+		if option == "http" {
+			cfg.HTTPAddrs = append(cfg.HTTPAddrs, args...)
+		} else {
+			cfg.HTTPSAddrs = append(cfg.HTTPSAddrs, args...)
+		}
+	case "template":
+		if !c.NextArg() {
+			return c.Err("No template file for the block page defined")
+		}
+		cfg.TemplateFile = c.Val()
+	case "unblock-url":
+		if !c.NextArg() {
+			return c.Err("No URL to request unblocking defined")
+		}
+		u, err := url.Parse(c.Val())
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "mailto" {
+			return c.Errf("Invalid unblock URL %q", c.Val())
+		}
+		cfg.UnblockURL = u
+	case "ca":
+		args := c.RemainingArgs()
+		if len(args) != 2 {
+			return c.Err("The CA has to be defined as 'ca <CERT FILE> <KEY FILE>'")
+		}
+		cfg.CACertFile, cfg.CAKeyFile = args[0], args[1]
+	default:
+		return c.Errf("Unknown block-page-server option %q", c.Val())
+	}
+	return nil
+}
+
+// setDefaults listens on the target addresses if no addresses have been
+// configured. HTTPS is only served if a CA is configured. Unspecified target
+// addresses are rejected, the server would listen on all interfaces.
+func (cfg *blockPageServerConfig) setDefaults(targets ...net.IP) error {
+	defaults := func(port string) ([]string, error) {
+		addrs := make([]string, 0, len(targets))
+		for _, ip := range targets {
+			if ip.IsUnspecified() {
+				return nil, fmt.Errorf("The block page server cannot listen on the target address %s, define its addresses using http and https", ip.String())
+			}
+			addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+		}
+		return addrs, nil
+	}
+
+	var err error
+	if len(cfg.HTTPAddrs) == 0 {
+		if cfg.HTTPAddrs, err = defaults("80"); err != nil {
+			return err
+		}
+	}
+	if len(cfg.HTTPSAddrs) == 0 && cfg.CACertFile != "" {
+		if cfg.HTTPSAddrs, err = defaults("443"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isBlockPageHost checks if name is the block page host of any response.
+func (cfg *adsPluginConfig) isBlockPageHost(name string) bool {
+	name = dns.Fqdn(name)
+	if cfg.BlockPageHost == name {
+		return true
+	}
+	for _, actions := range []map[string]*blockAction{cfg.BlacklistRuleActions, cfg.RegexBlacklistRuleActions} {
+		for _, action := range actions {
+			if action.BlockPageHost == name {
+				return true
+			}
+		}
+	}
+	for _, source := range cfg.ListSources {
+		if source.Action != nil && source.Action.BlockPageHost == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Flaque/filet"
+	"github.com/coredns/caddy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockPageServer_Page(t *testing.T) {
+	unblockURL, _ := url.Parse("https://helpdesk.corp.internal/unblock?source=dns")
+	s, err := newBlockPageServer(&blockPageServerConfig{UnblockURL: unblockURL})
+	assert.NoError(t, err)
+	s.Plugin = initTestPlugin(t, BuildRuleset(make([]string, 0), []string{"ads.example.com"}))
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://Ads.Example.com:8080/banner.js", nil))
+
+	assert.Equal(t, http.StatusUnavailableForLegalReasons, rec.Code)
+	body := rec.Body.String()
+	assert.Contains(t, body, "<h1>ads.example.com has been blocked</h1>")
+	assert.Contains(t, body, "<li>Corefile</li>")
+	assert.Contains(t, body, `href="https://helpdesk.corp.internal/unblock?host=ads.example.com&amp;source=dns"`)
+}
+
+func TestBlockPageServer_Template(t *testing.T) {
+	tmpdir := filet.TmpDir(t, "")
+	defer filet.CleanUp(t)
+
+	path := filepath.Join(tmpdir, "page.html")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("Blocked: {{.Host}}"), 0644))
+
+	s, err := newBlockPageServer(&blockPageServerConfig{TemplateFile: path})
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://<script>/", nil))
+	assert.Equal(t, "Blocked: &lt;script&gt;", rec.Body.String())
+}
+
+func TestBlockPageServer_CA(t *testing.T) {
+	tmpdir := filet.TmpDir(t, "")
+	defer filet.CleanUp(t)
+
+	certFile, keyFile := filepath.Join(tmpdir, "ca.crt"), filepath.Join(tmpdir, "ca.key")
+	ca, err := loadOrCreateBlockPageCA(certFile, keyFile)
+	assert.NoError(t, err)
+
+	// The CA is reused on the next start
+	reloaded, err := loadOrCreateBlockPageCA(certFile, keyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, ca.cert.Raw, reloaded.cert.Raw)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	cert, err := reloaded.certificate(&tls.ClientHelloInfo{ServerName: "ads.example.com"})
+	assert.NoError(t, err)
+	_, err = cert.Leaf.Verify(x509.VerifyOptions{DNSName: "ads.example.com", Roots: roots})
+	assert.NoError(t, err)
+
+	cached, err := reloaded.certificate(&tls.ClientHelloInfo{ServerName: "ads.example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, cert, cached)
+
+	cert, err = reloaded.certificate(&tls.ClientHelloInfo{ServerName: "127.0.0.1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("127.0.0.1").To4()}, cert.Leaf.IPAddresses)
+
+	assert.NoError(t, ioutil.WriteFile(certFile, []byte{}, 0644))
+	_, err = loadOrCreateBlockPageCA(certFile, filepath.Join(tmpdir, "missing.key"))
+	assert.Error(t, err)
+}
+
+func TestBlockPageServer_Certificates(t *testing.T) {
+	tmpdir := filet.TmpDir(t, "")
+	defer filet.CleanUp(t)
+
+	s, err := newBlockPageServer(&blockPageServerConfig{
+		CACertFile: filepath.Join(tmpdir, "ca.crt"),
+		CAKeyFile:  filepath.Join(tmpdir, "ca.key"),
+	})
+	assert.NoError(t, err)
+	s.Plugin = initTestPlugin(t, BuildRuleset([]string{"allowed.example.com"}, []string{"ads.example.com", "allowed.example.com"}))
+	s.Plugin.config.BlockPageHost = "blocked.corp.internal."
+
+	// Certificates are only issued for blocked names and the block page host
+	for _, name := range []string{"Ads.Example.com", "blocked.corp.internal"} {
+		_, err = s.certificate(&tls.ClientHelloInfo{ServerName: name})
+		assert.NoError(t, err, name)
+	}
+	for _, name := range []string{"bank.example.com", "allowed.example.com"} {
+		_, err = s.certificate(&tls.ClientHelloInfo{ServerName: name})
+		assert.Error(t, err, name)
+	}
+	assert.True(t, s.ca.cert.NotAfter.Before(time.Now().Add(blockPageCAValidity+time.Hour)))
+}
+
+func TestBlockPageServer_StartStop(t *testing.T) {
+	tmpdir := filet.TmpDir(t, "")
+	defer filet.CleanUp(t)
+
+	s, err := newBlockPageServer(&blockPageServerConfig{
+		HTTPAddrs:  []string{"127.0.0.1:0"},
+		HTTPSAddrs: []string{"127.0.0.1:0"},
+		CACertFile: filepath.Join(tmpdir, "ca.crt"),
+		CAKeyFile:  filepath.Join(tmpdir, "ca.key"),
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Start())
+	assert.Len(t, s.servers, 2)
+	assert.NoError(t, s.Stop())
+	assert.Empty(t, s.servers)
+
+	s, err = newBlockPageServer(&blockPageServerConfig{HTTPAddrs: []string{"256.0.0.1:80"}})
+	assert.NoError(t, err)
+	assert.Error(t, s.Start())
+}
+
+func TestSetup_BlockPageServer(t *testing.T) {
+	c := caddy.NewTestController("dns", `ads {
+  target 10.0.0.80
+  block-page-server {
+    unblock-url https://helpdesk.corp.internal/unblock
+    ca /etc/coredns/ca.crt /etc/coredns/ca.key
+  }
+}`)
+	c.Next()
+	cfg, err := parsePluginConfiguration(c)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.80:80", "[::1]:80"}, cfg.BlockPageServer.HTTPAddrs)
+	assert.Equal(t, []string{"10.0.0.80:443", "[::1]:443"}, cfg.BlockPageServer.HTTPSAddrs)
+
+	// Unspecified targets require explicit addresses
+	c = caddy.NewTestController("dns", "ads {\n target 0.0.0.0\n block-page-server {\n http 10.0.0.80:80\n }\n}")
+	c.Next()
+	cfg, err = parsePluginConfiguration(c)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.80:80"}, cfg.BlockPageServer.HTTPAddrs)
+
+	for _, v := range []string{
+		"ads {\n block-page-server {\n https 10.0.0.80:443\n }\n}",
+		"ads {\n block-page-server {\n http 10.0.0.80\n }\n}",
+		"ads {\n block-page-server {\n unblock-url ftp://example.com\n }\n}",
+		"ads {\n block-page-server {\n listen 10.0.0.80:80\n }\n}",
+		"ads {\n target 0.0.0.0\n block-page-server {\n }\n}",
+		"ads {\n target 0.0.0.0\n block-page-server {\n http 10.0.0.80:80\n ca /etc/coredns/ca.crt /etc/coredns/ca.key\n }\n}",
+	} {
+		c = caddy.NewTestController("dns", v)
+		c.Next()
+		_, err = parsePluginConfiguration(c)
+		assert.Error(t, err, v)
+	}
+}
@@ -16,6 +16,8 @@
 package ads
 
 import (
+	"context"
+	"github.com/coredns/coredns/plugin/metrics"
 	"github.com/coredns/coredns/request"
 	"github.com/miekg/dns"
 	"net"
@@ -23,6 +25,7 @@ import (
 )
 
 type BlockingResponseWriter struct {
+	Context      context.Context
 	Writer       dns.ResponseWriter
 	Plugin       *DNSAdBlock
 	Request      *dns.Msg
@@ -37,28 +40,142 @@ func (b *BlockingResponseWriter) RemoteAddr() net.Addr {
 	return b.Writer.RemoteAddr()
 }
 
+// Reasons for blocking a response, used as metric label
+const (
+	blockReasonName       = "name"
+	blockReasonNetwork    = "network"
+	blockReasonRebinding  = "rebinding"
+	blockReasonCnameCloak = "cname-cloak"
+)
+
 func (b *BlockingResponseWriter) WriteMsg(msg *dns.Msg) error {
+	if name, reason, blocked := b.inspect(msg); blocked {
+		return b.block(name, reason)
+	}
+	b.dropRebinding(msg)
+	return b.Writer.WriteMsg(msg)
+}
+
+// inspect checks if the response has to be blocked. It returns the name
+// causing the block and the reason.
+func (b *BlockingResponseWriter) inspect(msg *dns.Msg) (string, string, bool) {
 	for _, rr := range msg.Answer {
-		host := ""
+		if b.Plugin.inspects(rr.Header().Rrtype) {
+			for _, name := range inspectedNames(rr) {
+				if b.Plugin.ShouldBlock(name) {
+					return name, blockReasonName, true
+				}
+			}
+			if target, ok := cnameTarget(rr); ok && b.isCloakedTracker(target) {
+				return target, blockReasonCnameCloak, true
+			}
+		}
+
+		host := strings.TrimSuffix(rr.Header().Name, ".")
+		var ip net.IP
 		switch v := rr.(type) {
-		case *dns.CNAME:
-			host = strings.TrimSuffix(v.Target, ".")
 		case *dns.A:
-			host = strings.TrimSuffix(v.Hdr.Name, ".")
+			ip = v.A
 		case *dns.AAAA:
-			host = strings.TrimSuffix(v.Hdr.Name, ".")
+			ip = v.AAAA
 		default:
 			continue
 		}
-		if b.Plugin.ShouldBlock(host) {
-			return b.Plugin.onBlock(b, b.Request, b.RequestState, host)
+		if b.shouldBlockAddress(host, ip) {
+			if b.Plugin.config.EnableLogging {
+				log.Infof("Answer %q of request %q resolves to blocked address %s", host, b.RequestState.Name(), ip.String())
+			}
+			return host, blockReasonNetwork, true
 		}
 	}
-	return b.Writer.WriteMsg(msg)
+	return "", "", false
 }
 
+// dropRebinding removes the internal addresses of public names from the
+// response. Replacing them by the blocking response would just point the
+// client to another internal address. It returns true if records have been
+// removed.
+func (b *BlockingResponseWriter) dropRebinding(msg *dns.Msg) bool {
+	if !b.Plugin.config.EnableRebindingProtection {
+		return false
+	}
+
+	dropped := false
+	filter := func(rrs []dns.RR) []dns.RR {
+		kept := make([]dns.RR, 0, len(rrs))
+		for _, rr := range rrs {
+			var ip net.IP
+			switch v := rr.(type) {
+			case *dns.A:
+				ip = v.A
+			case *dns.AAAA:
+				ip = v.AAAA
+			}
+			if ip != nil && b.Plugin.isRebinding(b.RequestState.Name(), ip) {
+				log.Warningf("Possible DNS rebinding attack: %q resolves to internal address %s, removing the address from the response",
+					rr.Header().Name, ip.String())
+				dropped = true
+				continue
+			}
+			kept = append(kept, rr)
+		}
+		return kept
+	}
+	msg.Answer = filter(msg.Answer)
+	msg.Extra = filter(msg.Extra)
+
+	if dropped {
+		blockedResponseCount.WithLabelValues(metrics.WithServer(b.Context), blockReasonRebinding).Inc()
+	}
+	return dropped
+}
+
+// block replaces the response with the blocking response. It is written to
+// the wrapped writer, since the target address might be blocked itself.
+func (b *BlockingResponseWriter) block(name, reason string) error {
+	blockedResponseCount.WithLabelValues(metrics.WithServer(b.Context), reason).Inc()
+	return b.Plugin.onBlock(b.Context, b.Writer, b.Request, b.RequestState, name)
+}
+
+// shouldBlockAddress checks if an answer resolves to a blacklisted network.
+// Whitelisted names are never blocked because of their addresses.
+func (b *BlockingResponseWriter) shouldBlockAddress(host string, ip net.IP) bool {
+	qname := strings.TrimSuffix(b.RequestState.Name(), ".")
+	return b.Plugin.IsNetworkBlacklisted(ip) && !b.Plugin.IsWhitelisted(host) && !b.Plugin.IsWhitelisted(qname)
+}
+
+// isCloakedTracker checks if a CNAME points to a tracker hiding behind a
+// first-party subdomain.
+func (b *BlockingResponseWriter) isCloakedTracker(target string) bool {
+	qname := strings.TrimSuffix(b.RequestState.Name(), ".")
+	entry, ok := b.Plugin.CnameCloakingTarget(target)
+	if !ok || b.Plugin.IsWhitelisted(target) || b.Plugin.IsWhitelisted(qname) {
+		return false
+	}
+	if b.Plugin.config.EnableLogging {
+		if sources := b.Plugin.cnameCloakingSources(entry); len(sources) > 0 {
+			log.Infof("Request %q points to cloaked tracker %q (cname-cloak %q, lists: %s)", qname, target, entry, strings.Join(sources, ", "))
+		} else {
+			log.Infof("Request %q points to cloaked tracker %q (cname-cloak %q)", qname, target, entry)
+		}
+	}
+	return true
+}
+
+// Write inspects responses written as wire data just like WriteMsg. Data
+// that cannot be unpacked is passed through unchanged.
 func (b *BlockingResponseWriter) Write(bytes []byte) (int, error) {
-	log.Warning("'ads' called with Write: CNAME blocking therefore does not work")
+	msg := new(dns.Msg)
+	if err := msg.Unpack(bytes); err != nil {
+		log.Warningf("'ads' called with Write using a malformed message, the response is not inspected: %s", err.Error())
+		return b.Writer.Write(bytes)
+	}
+	if name, reason, blocked := b.inspect(msg); blocked {
+		return len(bytes), b.block(name, reason)
+	}
+	if b.dropRebinding(msg) {
+		return len(bytes), b.Writer.WriteMsg(msg)
+	}
 	return b.Writer.Write(bytes)
 }
 
@@ -0,0 +1,106 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// wireRecorder records responses written as message or as wire data.
+type wireRecorder struct {
+	test.ResponseWriter
+	msg  *dns.Msg
+	wire []byte
+}
+
+func (w *wireRecorder) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+
+func (w *wireRecorder) Write(buf []byte) (int, error) {
+	w.wire = buf
+	return len(buf), nil
+}
+
+// wireHandler answers queries using Write instead of WriteMsg.
+func wireHandler(records map[string][]string, malformed bool) test.Handler {
+	return test.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		for _, v := range records[r.Question[0].Name] {
+			rr, err := dns.NewRR(v)
+			if err != nil {
+				return dns.RcodeServerFailure, err
+			}
+			m.Answer = append(m.Answer, rr)
+		}
+		data, err := m.Pack()
+		if err != nil {
+			return dns.RcodeServerFailure, err
+		}
+		if malformed {
+			data = data[:len(data)-2]
+		}
+		_, err = w.Write(data)
+		return dns.RcodeSuccess, err
+	})
+}
+
+func TestBlockingResponseWriter_Write(t *testing.T) {
+	records := map[string][]string{
+		"www.test.tld.": {
+			"www.test.tld. 3600 IN CNAME tracker.test.tld.",
+			"tracker.test.tld. 3600 IN A 203.0.113.10",
+		},
+		"good.test.tld.": {"good.test.tld. 3600 IN A 203.0.113.11"},
+	}
+	p := initTestPlugin(t, BuildRuleset(make([]string, 0), []string{"tracker.test.tld"}))
+
+	query := func(qname string) *wireRecorder {
+		w := &wireRecorder{}
+		_, err := p.ServeDNS(context.TODO(), w, test.Case{Qname: qname, Qtype: dns.TypeA}.Msg())
+		assert.NoError(t, err)
+		return w
+	}
+
+	p.Next = wireHandler(records, false)
+
+	w := query("www.test.tld")
+	assert.Nil(t, w.wire)
+	if assert.NotNil(t, w.msg) && assert.Len(t, w.msg.Answer, 1) {
+		assert.Equal(t, p.config.TargetIP.String(), w.msg.Answer[0].(*dns.A).A.String())
+	}
+
+	w = query("good.test.tld")
+	assert.Nil(t, w.msg)
+	response := new(dns.Msg)
+	assert.NoError(t, response.Unpack(w.wire))
+	assert.Len(t, response.Answer, 1)
+
+	// Malformed messages are passed through
+	p.Next = wireHandler(records, true)
+
+	w = query("www.test.tld")
+	assert.Nil(t, w.msg)
+	assert.NotEmpty(t, w.wire)
+}
@@ -0,0 +1,246 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+)
+
+// builtinCatalogData is the list catalog shipped with the plugin. Entries can
+// be replaced, added and retired using a local catalog file (list-catalog).
+//
+//go:embed lists/catalog.json
+var builtinCatalogData []byte
+
+// Formats of catalog lists, both are handled by the same parser
+const (
+	listFormatHosts   = "hosts"
+	listFormatDomains = "domains"
+)
+
+type catalogEntry struct {
+	Name       string   `json:"name"`
+	URL        string   `json:"url"`
+	Format     string   `json:"format"`
+	Kind       listKind `json:"kind,omitempty"`
+	Categories []string `json:"categories,omitempty"`
+	License    string   `json:"license,omitempty"`
+	// ExpectedSize is the approximate number of entries of the list
+	ExpectedSize int  `json:"expected_size,omitempty"`
+	Retired      bool `json:"retired,omitempty"`
+}
+
+type listCatalog struct {
+	Lists []*catalogEntry `json:"lists"`
+
+	byName map[string]*catalogEntry
+}
+
+func parseListCatalog(data []byte) (*listCatalog, error) {
+	catalog := &listCatalog{}
+	if err := json.Unmarshal(data, catalog); err != nil {
+		return nil, err
+	}
+	catalog.byName = make(map[string]*catalogEntry)
+	for _, e := range catalog.Lists {
+		if err := e.validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := catalog.byName[e.Name]; ok {
+			return nil, fmt.Errorf("the list %q is defined multiple times", e.Name)
+		}
+		catalog.byName[e.Name] = e
+	}
+	return catalog, nil
+}
+
+// loadListCatalog loads the built-in catalog and applies the local catalog
+// file on top of it, if defined.
+func loadListCatalog(overlayPath string) (*listCatalog, error) {
+	catalog, err := parseListCatalog(builtinCatalogData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid built-in list catalog: %s", err.Error())
+	}
+	if overlayPath == "" {
+		return catalog, nil
+	}
+
+	data, err := ioutil.ReadFile(overlayPath)
+	if err != nil {
+		return nil, err
+	}
+	overlay, err := parseListCatalog(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid list catalog %q: %s", overlayPath, err.Error())
+	}
+	catalog.overlay(overlay)
+	return catalog, nil
+}
+
+// overlay replaces the entries of the catalog by the entries of o with the
+// same name. Entries defining only a name are used to retire an entry.
+func (c *listCatalog) overlay(o *listCatalog) {
+	for _, e := range o.Lists {
+		existing, ok := c.byName[e.Name]
+		if !ok {
+			c.Lists = append(c.Lists, e)
+			c.byName[e.Name] = e
+		} else if e.URL == "" {
+			existing.Retired = e.Retired
+		} else {
+			*existing = *e
+		}
+	}
+}
+
+func (c *listCatalog) lookup(name string) (*catalogEntry, bool) {
+	e, ok := c.byName[name]
+	return e, ok
+}
+
+// ofCategory returns the active blacklists of the given category.
+func (c *listCatalog) ofCategory(category string) []*catalogEntry {
+	entries := make([]*catalogEntry, 0)
+	for _, e := range c.Lists {
+		if !e.Retired && e.kind() == listKindBlacklist && e.hasCategory(category) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+func (e *catalogEntry) validate() error {
+	if e.Name == "" {
+		return fmt.Errorf("list without name")
+	}
+	if e.URL == "" {
+		if !e.Retired {
+			return fmt.Errorf("the list %q has no URL", e.Name)
+		}
+		return nil
+	}
+	if u, err := url.Parse(e.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("the list %q has an invalid URL, only HTTP URLs are supported", e.Name)
+	}
+	if e.Format != listFormatHosts && e.Format != listFormatDomains {
+		return fmt.Errorf("the list %q has the unknown format %q", e.Name, e.Format)
+	}
+	switch e.kind() {
+	case listKindBlacklist, listKindWhitelist, listKindNetworks, listKindCnameCloaking:
+	default:
+		return fmt.Errorf("the list %q has the unknown kind %q", e.Name, e.Kind)
+	}
+	for _, category := range e.Categories {
+		if !knownCategories[category] {
+			return fmt.Errorf("the list %q has the unknown category %q", e.Name, category)
+		}
+	}
+	return nil
+}
+
+func (e *catalogEntry) kind() listKind {
+	if e.Kind == "" {
+		return listKindBlacklist
+	}
+	return e.Kind
+}
+
+func (e *catalogEntry) hasCategory(category string) bool {
+	for _, v := range e.Categories {
+		if v == category {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveCatalogLists adds the lists selected by name or category to the
+// configuration. The default lists are used if no blacklist URL has been
+// configured at all.
+func (cfg *adsPluginConfig) resolveCatalogLists() error {
+	catalog, err := loadListCatalog(cfg.CatalogPath)
+	if err != nil {
+		return err
+	}
+	if err := cfg.addCatalogLists(catalog, cfg.CatalogLists); err != nil {
+		return err
+	}
+	for _, category := range cfg.BlockedCategories {
+		entries := catalog.ofCategory(category)
+		if len(entries) == 0 {
+			return fmt.Errorf("no lists of category %q available", category)
+		}
+		for _, e := range entries {
+			cfg.addCatalogEntry(e)
+		}
+	}
+
+	if len(cfg.BlacklistURLs) == 0 {
+		return cfg.addCatalogLists(catalog, defaultBlacklists)
+	}
+	return nil
+}
+
+// addCatalogLists adds the catalog entries with the given names to the
+// configuration. Retired entries are skipped.
+func (cfg *adsPluginConfig) addCatalogLists(catalog *listCatalog, names []string) error {
+	for _, name := range names {
+		e, ok := catalog.lookup(name)
+		if !ok {
+			return fmt.Errorf("unknown list %q, it is not part of the list catalog", name)
+		}
+		if e.Retired {
+			log.Infof("Skipping list %q, it has been retired", name)
+			continue
+		}
+		cfg.addCatalogEntry(e)
+	}
+	return nil
+}
+
+// addCatalogEntry adds a single catalog entry to the configuration. Lists
+// that have already been configured keep their options.
+func (cfg *adsPluginConfig) addCatalogEntry(e *catalogEntry) {
+	if source, ok := cfg.ListSources[e.URL]; ok {
+		if source.Kind == e.kind() && len(source.Categories) == 0 {
+			source.Categories = e.Categories
+		}
+		return
+	}
+
+	switch e.kind() {
+	case listKindWhitelist:
+		cfg.WhitelistURLs = append(cfg.WhitelistURLs, e.URL)
+	case listKindNetworks:
+		cfg.NetworkBlacklistURLs = append(cfg.NetworkBlacklistURLs, e.URL)
+	case listKindCnameCloaking:
+		cfg.CnameCloakingURLs = append(cfg.CnameCloakingURLs, e.URL)
+	default:
+		cfg.BlacklistURLs = append(cfg.BlacklistURLs, e.URL)
+	}
+	cfg.ListSources[e.URL] = &listSourceConfig{
+		URL:          e.URL,
+		Kind:         e.kind(),
+		Order:        len(cfg.ListSources),
+		Categories:   e.Categories,
+		ExpectedSize: e.ExpectedSize,
+	}
+}
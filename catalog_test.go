@@ -0,0 +1,91 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListCatalog_Builtin(t *testing.T) {
+	catalog, err := loadListCatalog("")
+	assert.NoError(t, err)
+
+	docs, err := ioutil.ReadFile("docs/lists.md")
+	assert.NoError(t, err)
+	for _, e := range catalog.Lists {
+		if e.kind() == listKindBlacklist {
+			assert.NotEmpty(t, e.Categories, e.Name)
+		}
+		assert.True(t, strings.Contains(string(docs), "`"+e.Name+"`"), "%q is not documented", e.Name)
+	}
+
+	sets := [][]string{defaultBlacklists, strictDefaultBlacklists, strictDefaultWhitelists, defaultCnameCloakingLists}
+	for _, set := range sets {
+		for _, name := range set {
+			e, ok := catalog.lookup(name)
+			if assert.True(t, ok, name) {
+				assert.False(t, e.Retired, "%q is retired", name)
+			}
+		}
+	}
+}
+
+func TestListCatalog_Overlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ads-catalog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "catalog.json")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`{"lists": [
+  {"name": "stevenblack-hosts", "retired": true},
+  {"name": "cameleon", "url": "https://mirror.example.com/cameleon", "format": "hosts", "categories": ["ads"]},
+  {"name": "corp-malware", "url": "https://lists.example.com/malware.txt", "format": "domains", "categories": ["malware"]}
+]}`), 0644))
+
+	c := caddy.NewTestController("dns", "ads {\n default-lists\n list corp-malware\n list-catalog "+path+"\n}")
+	c.Next()
+	cfg, err := parsePluginConfiguration(c)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"https://mirror.example.com/cameleon",
+		"https://s3.amazonaws.com/lists.disconnect.me/simple_tracking.txt",
+		"https://s3.amazonaws.com/lists.disconnect.me/simple_ad.txt",
+		"https://lists.example.com/malware.txt",
+	}, cfg.BlacklistURLs)
+	assert.Equal(t, []string{categoryMalware}, cfg.ListSources["https://lists.example.com/malware.txt"].Categories)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`{"lists": [{"name": "broken", "url": "ftp://example.com/list", "format": "hosts"}]}`), 0644))
+	for _, v := range []string{
+		"ads {\n list\n}",
+		"ads {\n list unknown-list\n}",
+		"ads {\n list-catalog\n}",
+		"ads {\n list-catalog " + filepath.Join(dir, "missing.json") + "\n}",
+		"ads {\n list-catalog " + path + "\n}",
+	} {
+		c = caddy.NewTestController("dns", v)
+		c.Next()
+		_, err = parsePluginConfiguration(c)
+		assert.Error(t, err, v)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/coredns/caddy"
+)
+
+// Categories of blacklist entries
+const (
+	categoryAds          = "ads"
+	categoryTracking     = "tracking"
+	categoryMalware      = "malware"
+	categoryPhishing     = "phishing"
+	categoryAdult        = "adult"
+	categoryGambling     = "gambling"
+	categorySocial       = "social"
+	categoryCryptoMining = "crypto-mining"
+	categorySpam         = "spam"
+)
+
+// uncategorized is used as metric label for blocks without category, e.g.
+// by rules defined in the Corefile
+const uncategorized = "uncategorized"
+
+var knownCategories = map[string]bool{
+	categoryAds:          true,
+	categoryTracking:     true,
+	categoryMalware:      true,
+	categoryPhishing:     true,
+	categoryAdult:        true,
+	categoryGambling:     true,
+	categorySocial:       true,
+	categoryCryptoMining: true,
+	categorySpam:         true,
+}
+
+// parseCategories parses the remaining arguments of the current line as
+// list of categories.
+func parseCategories(c *caddy.Controller) ([]string, error) {
+	args := c.RemainingArgs()
+	if len(args) == 0 {
+		return nil, c.Err("No category defined")
+	}
+	categories := make([]string, 0, len(args))
+	for _, v := range args {
+		category := strings.ToLower(v)
+		if !knownCategories[category] {
+			return nil, c.Errf("Unknown category %q", v)
+		}
+		categories = append(categories, category)
+	}
+	return categories, nil
+}
+
+// applyCategoryPolicy drops all blacklists whose categories are permitted
+// completely. Lists having at least one blocked category are still loaded,
+// as are lists without categories.
+func (cfg *adsPluginConfig) applyCategoryPolicy() {
+	if len(cfg.PermittedCategories) == 0 {
+		return
+	}
+	filter := func(keys []string) []string {
+		kept := make([]string, 0, len(keys))
+		for _, key := range keys {
+			if source := cfg.ListSources[key]; source != nil && source.isPermitted(cfg.PermittedCategories) {
+				log.Infof("Skipping list %q, its categories (%s) are permitted", key, strings.Join(source.Categories, ", "))
+				continue
+			}
+			kept = append(kept, key)
+		}
+		return kept
+	}
+	cfg.BlacklistURLs = filter(cfg.BlacklistURLs)
+	cfg.BlacklistFiles = filter(cfg.BlacklistFiles)
+}
+
+func (s *listSourceConfig) isPermitted(permitted map[string]bool) bool {
+	if len(s.Categories) == 0 {
+		return false
+	}
+	for _, category := range s.Categories {
+		if !permitted[category] {
+			return false
+		}
+	}
+	return true
+}
+
+// blockCategories returns the categories of all lists containing qname.
+func (e *DNSAdBlock) blockCategories(qname string) []string {
+	if e.updater == nil {
+		return nil
+	}
+	found := make(map[string]bool)
+	for _, key := range e.updater.matchingSources(qname, listKindBlacklist) {
+		if source := e.config.ListSources[key]; source != nil {
+			for _, category := range source.Categories {
+				found[category] = true
+			}
+		}
+	}
+	categories := make([]string, 0, len(found))
+	for category := range found {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	return categories
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetup_Categories(t *testing.T) {
+	c := caddy.NewTestController("dns", `ads {
+ block-category crypto-mining
+ blacklist https://example.com/casinos.txt {
+  category gambling
+ }
+ blacklist https://example.com/mixed.txt {
+  category gambling malware
+ }
+ blacklist https://example.com/plain.txt
+ permit-category gambling
+}`)
+	c.Next()
+	cfg, err := parsePluginConfiguration(c)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"https://example.com/mixed.txt",
+		"https://example.com/plain.txt",
+		"https://zerodot1.gitlab.io/CoinBlockerLists/hosts",
+	}, cfg.BlacklistURLs)
+	assert.Equal(t, []string{categoryCryptoMining}, cfg.ListSources["https://zerodot1.gitlab.io/CoinBlockerLists/hosts"].Categories)
+
+	for _, v := range []string{
+		"ads {\n block-category\n}",
+		"ads {\n block-category fishing\n}",
+		"ads {\n block-category gambling\n}",
+		"ads {\n block-category adult\n}",
+		"ads {\n block-category malware\n permit-category malware\n}",
+		"ads {\n whitelist https://example.com/list.txt {\n category ads\n }\n}",
+	} {
+		c = caddy.NewTestController("dns", v)
+		c.Next()
+		_, err = parsePluginConfiguration(c)
+		assert.Error(t, err, v)
+	}
+}
+
+func TestSetup_DefaultListCategories(t *testing.T) {
+	c := caddy.NewTestController("dns", "ads {\n permit-category tracking\n}")
+	c.Next()
+	cfg, err := parsePluginConfiguration(c)
+	assert.NoError(t, err)
+	assert.NotContains(t, cfg.BlacklistURLs, "https://s3.amazonaws.com/lists.disconnect.me/simple_tracking.txt")
+	assert.Contains(t, cfg.BlacklistURLs, "https://s3.amazonaws.com/lists.disconnect.me/simple_ad.txt")
+}
+
+func TestBlockCategories(t *testing.T) {
+	p := initTestPlugin(t, getEmptyRuleset())
+	p.config.ListSources = map[string]*listSourceConfig{
+		"https://example.com/ads.txt":    {Categories: []string{categoryAds, categoryTracking}},
+		"https://example.com/casino.txt": {Categories: []string{categoryGambling}},
+		"https://example.com/plain.txt":  {},
+	}
+	p.updater = &ListUpdater{Plugin: p}
+	p.updater.sources = []*listSourceState{
+		{URL: "https://example.com/ads.txt", Kind: listKindBlacklist, list: ListMap{"both.example.com": true}},
+		{URL: "https://example.com/casino.txt", Kind: listKindBlacklist, list: ListMap{"both.example.com": true}},
+		{URL: "https://example.com/plain.txt", Kind: listKindBlacklist, list: ListMap{"plain.example.com": true}},
+	}
+
+	assert.Equal(t, []string{categoryAds, categoryGambling, categoryTracking}, p.blockCategories("both.example.com"))
+	assert.Empty(t, p.blockCategories("plain.example.com"))
+}
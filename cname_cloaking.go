@@ -0,0 +1,77 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// matchesDomain checks if the name or one of its parent domains is part of
+// the list.
+func matchesDomain(list map[string]bool, name string) (string, bool) {
+	for {
+		if list[name] {
+			return name, true
+		}
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			return "", false
+		}
+		name = name[i+1:]
+	}
+}
+
+// CnameCloakingTarget checks if name belongs to a tracker hiding behind
+// first-party subdomains. Unlike the blacklist, an entry matches all of its
+// subdomains since trackers usually assign one subdomain to every customer.
+// The matching entry is returned if the name is a cloaking target.
+func (e *DNSAdBlock) CnameCloakingTarget(name string) (string, bool) {
+	e = e.active()
+	lists := e.updater.lists()
+	if entry, ok := matchesDomain(lists.http.CnameCloakingTargets, name); ok {
+		return entry, true
+	}
+	if entry, ok := matchesDomain(e.ConfiguredRuleSet.CnameCloakingTargets, name); ok {
+		return entry, true
+	}
+	return matchesDomain(lists.files.CnameCloakingTargets, name)
+}
+
+// cnameTarget returns the target of CNAME and DNAME records.
+func cnameTarget(rr dns.RR) (string, bool) {
+	switch v := rr.(type) {
+	case *dns.CNAME:
+		return strings.TrimSuffix(strings.ToLower(v.Target), "."), true
+	case *dns.DNAME:
+		return strings.TrimSuffix(strings.ToLower(v.Target), "."), true
+	}
+	return "", false
+}
+
+// cnameCloakingSources returns the origin of a CNAME cloaking entry.
+func (e *DNSAdBlock) cnameCloakingSources(entry string) []string {
+	sources := make([]string, 0)
+	if e.ConfiguredRuleSet.CnameCloakingTargets[entry] {
+		sources = append(sources, "Corefile")
+	}
+	if e.updater != nil {
+		sources = append(sources, e.updater.Provenance(entry, listKindCnameCloaking)...)
+	}
+	return sources
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookup_CnameCloaking(t *testing.T) {
+	ruleset := BuildRuleset([]string{"permitted.test.tld"}, make([]string, 0))
+	ruleset.AddCnameCloakingTarget("eulerian.net")
+
+	p := initTestPlugin(t, ruleset)
+	p.updater = testListUpdater(UpdateableRuleset{CnameCloakingTargets: ListMap{"at-o.net": true}})
+	p.Next = upstreamHandler(map[string][]string{
+		"metrics.shop.test.tld.": {
+			"metrics.shop.test.tld. 3600 IN CNAME shop.eulerian.net.",
+			"shop.eulerian.net. 3600 IN A 203.0.113.10",
+		},
+		"stats.shop.test.tld.": {
+			"stats.shop.test.tld. 3600 IN CNAME shop.at-o.net.",
+			"shop.at-o.net. 3600 IN A 203.0.113.11",
+		},
+		"www.shop.test.tld.": {
+			"www.shop.test.tld. 3600 IN CNAME shop.noteulerian.net.",
+			"shop.noteulerian.net. 3600 IN A 203.0.113.12",
+		},
+		"permitted.test.tld.": {
+			"permitted.test.tld. 3600 IN CNAME permitted.eulerian.net.",
+			"permitted.eulerian.net. 3600 IN A 203.0.113.13",
+		},
+	})
+
+	before := testutil.ToFloat64(blockedResponseCount.WithLabelValues("", blockReasonCnameCloak))
+
+	testCases := []test.Case{
+		{
+			Qname: "metrics.shop.test.tld", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("metrics.shop.test.tld. 60 IN A 10.1.33.7")},
+		},
+		{
+			Qname: "stats.shop.test.tld", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("stats.shop.test.tld. 60 IN A 10.1.33.7")},
+		},
+		{
+			Qname: "www.shop.test.tld", Qtype: dns.TypeA,
+			Answer: []dns.RR{
+				test.A("shop.noteulerian.net. 3600 IN A 203.0.113.12"),
+				test.CNAME("www.shop.test.tld. 3600 IN CNAME shop.noteulerian.net."),
+			},
+		},
+		{
+			Qname: "permitted.test.tld", Qtype: dns.TypeA,
+			Answer: []dns.RR{
+				test.A("permitted.eulerian.net. 3600 IN A 203.0.113.13"),
+				test.CNAME("permitted.test.tld. 3600 IN CNAME permitted.eulerian.net."),
+			},
+		},
+	}
+	resolveTestCases(testCases, p, context.TODO(), t)
+
+	assert.Equal(t, before+2, testutil.ToFloat64(blockedResponseCount.WithLabelValues("", blockReasonCnameCloak)))
+}
+
+func TestSetup_CnameCloaking(t *testing.T) {
+	c := caddy.NewTestController("dns", `ads {
+  cname-cloaking
+  cname-cloaking-list file:///etc/coredns/cloaking.txt
+  cname-cloak tracker.example.com
+}`)
+	c.Next()
+	cfg, err := parsePluginConfiguration(c)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"https://raw.github.com/c-mueller/ads/master/lists/cname-cloaking.txt"}, cfg.CnameCloakingURLs)
+	assert.Equal(t, []string{"/etc/coredns/cloaking.txt"}, cfg.CnameCloakingFiles)
+	assert.Equal(t, []string{"tracker.example.com"}, cfg.CnameCloakingRules)
+
+	list := make(ListMap, 0)
+	data, err := ioutil.ReadFile("lists/cname-cloaking.txt")
+	assert.NoError(t, err)
+	parseListFile(data, list)
+	assert.True(t, list["eulerian.net"])
+}
@@ -21,63 +21,72 @@ import (
 	"time"
 )
 
+// The default list sets reference entries of the list catalog by name. They
+// must not contain retired entries, which are only kept in the catalog for
+// configurations naming them explicitly.
 var defaultBlacklists = []string{
-	"https://raw.githubusercontent.com/StevenBlack/hosts/master/hosts",
-	"https://mirror1.malwaredomains.com/files/justdomains",
-	"http://sysctl.org/cameleon/hosts",
-	"https://zeustracker.abuse.ch/blocklist.php?download=domainblocklist",
-	"https://s3.amazonaws.com/lists.disconnect.me/simple_tracking.txt",
-	"https://s3.amazonaws.com/lists.disconnect.me/simple_ad.txt",
+	"stevenblack-hosts",
+	"cameleon",
+	"disconnect-tracking",
+	"disconnect-ads",
 }
 
 var strictDefaultBlacklists = []string{
-	"https://raw.githubusercontent.com/StevenBlack/hosts/master/hosts",
-	"https://mirror1.malwaredomains.com/files/justdomains",
-	"http://sysctl.org/cameleon/hosts",
-	"https://s3.amazonaws.com/lists.disconnect.me/simple_tracking.txt",
-	"https://s3.amazonaws.com/lists.disconnect.me/simple_ad.txt",
-	"https://reddestdream.github.io/Projects/MinimalHosts/etc/MinimalHostsBlocker/minimalhosts",
-	"https://raw.githubusercontent.com/StevenBlack/hosts/master/data/KADhosts/hosts",
-	"https://raw.githubusercontent.com/StevenBlack/hosts/master/data/add.Spam/hosts",
-	"https://v.firebog.net/hosts/static/w3kbl.txt",
-	"https://v.firebog.net/hosts/BillStearns.txt",
-	"https://www.dshield.org/feeds/suspiciousdomains_Low.txt",
-	"https://www.dshield.org/feeds/suspiciousdomains_Medium.txt",
-	"https://www.dshield.org/feeds/suspiciousdomains_High.txt",
-	"https://www.joewein.net/dl/bl/dom-bl-base.txt",
-	"https://raw.githubusercontent.com/matomo-org/referrer-spam-blacklist/master/spammers.txt",
-	"https://hostsfile.org/Downloads/hosts.txt",
-	"https://someonewhocares.org/hosts/zero/hosts",
-	"https://raw.githubusercontent.com/Dawsey21/Lists/master/main-blacklist.txt",
-	"https://raw.githubusercontent.com/vokins/yhosts/master/hosts",
-	"https://hostsfile.mine.nu/hosts0.txt",
-	"https://list.kwbt.de/fritzboxliste.txt",
-	"https://adaway.org/hosts.txt",
-	"https://v.firebog.net/hosts/AdguardDNS.txt",
-	"https://raw.githubusercontent.com/anudeepND/blacklist/master/adservers.txt",
-	"https://s3.amazonaws.com/lists.disconnect.me/simple_ad.txt",
-	"https://v.firebog.net/hosts/Easylist.txt",
-	"https://pgl.yoyo.org/adservers/serverlist.php?hostformat=hosts;showintro=0",
-	"https://raw.githubusercontent.com/StevenBlack/hosts/master/data/UncheckyAds/hosts",
-	"https://www.squidblacklist.org/downloads/dg-ads.acl",
-	"https://v.firebog.net/hosts/Easyprivacy.txt",
-	"https://v.firebog.net/hosts/Prigent-Ads.txt",
-	"https://gitlab.com/quidsup/notrack-blocklists/raw/master/notrack-blocklist.txt",
-	"https://raw.githubusercontent.com/StevenBlack/hosts/master/data/add.2o7Net/hosts",
-	"https://raw.githubusercontent.com/crazy-max/WindowsSpyBlocker/master/data/hosts/spy.txt",
-	"https://zerodot1.gitlab.io/CoinBlockerLists/hosts",
-	"http://www.malwaredomainlist.com/hostslist/hosts.txt",
-	"http://www.malwaredomainlist.com/hostslist/delisted.txt",
-	"https://raw.github.com/jonschipp/mal-dnssearch/master/mandiant_apt1.dns",
+	"stevenblack-hosts",
+	"cameleon",
+	"disconnect-tracking",
+	"disconnect-ads",
+	"minimalhosts",
+	"kadhosts",
+	"add-spam",
+	"firebog-w3kbl",
+	"firebog-billstearns",
+	"dshield-low",
+	"dshield-medium",
+	"dshield-high",
+	"joewein-base",
+	"matomo-referrer-spam",
+	"hostsfile-org",
+	"someonewhocares",
+	"dawsey21",
+	"yhosts",
+	"hostsfile-mine-nu",
+	"kwbt-fritzbox",
+	"adaway",
+	"firebog-adguard",
+	"anudeepnd-adservers",
+	"firebog-easylist",
+	"yoyo",
+	"uncheckyads",
+	"squidblacklist-ads",
+	"firebog-easyprivacy",
+	"firebog-prigent-ads",
+	"notrack",
+	"add-2o7net",
+	"windowsspyblocker",
+	"coinblocker",
+	"mandiant-apt1",
 }
 
 var strictDefaultWhitelists = []string{
-	"https://raw.github.com/c-mueller/ads/master/lists/strict-whitelist.txt",
+	"ads-strict-whitelist",
+}
+
+var defaultCnameCloakingLists = []string{
+	"ads-cname-cloaking",
 }
 
 const defaultIPv4ResolutionIP = "127.0.0.1"
 const defaultIPv6ResolutionIP = "::1"
 
+// Blocked responses are cached only briefly, so unblocked names become
+// reachable quickly
+const defaultBlockTTL = 60
+
+const defaultListFetchWorkers = 4
+const defaultListFetchTimeout = 2 * time.Minute
+const defaultListMaxSize = 64 << 20
+
 var defaultConfigWithoutRules = adsPluginConfig{
 	BlacklistURLs: []string{},
 	WhitelistURLs: []string{},
@@ -99,9 +108,19 @@ var defaultConfigWithoutRules = adsPluginConfig{
 	ListRenewalRetryCount:    5,
 	ListRenewalRetryInterval: time.Minute,
 
+	ListFetchWorkers: defaultListFetchWorkers,
+	ListFetchTimeout: defaultListFetchTimeout,
+	ListMaxSize:      defaultListMaxSize,
+
 	ListPersistencePath:   "",
 	EnableLogging:         false,
 	EnableAutoUpdate:      true,
 	EnableListPersistence: false,
+	EnableFallbackList:    true,
+	StartupPolicy:         startupPolicyPassThrough,
 	WriteNXDomain:         false,
+
+	TargetTTL:    defaultBlockTTL,
+	BlockPageTTL: defaultBlockTTL,
+	NXDomainTTL:  defaultBlockTTL,
 }
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	_ "embed"
+)
+
+// fallbackListData is a compact baseline blacklist compiled into the plugin.
+// It is used until the first configured blacklist has been loaded, e.g. on
+// the first start of a system without internet connection.
+//
+//go:embed lists/fallback.txt
+var fallbackListData []byte
+
+func fallbackList() ListMap {
+	list := make(ListMap)
+	parseListFile(fallbackListData, list)
+	return list
+}
+
+// needsFallback checks if HTTP blacklists are configured, but none of them
+// has been loaded yet. The source mutex has to be held by the caller.
+func (u *ListUpdater) needsFallback() bool {
+	if !u.Fallback {
+		return false
+	}
+	configured := false
+	for _, s := range u.sources {
+		if s.Kind != listKindBlacklist {
+			continue
+		}
+		if s.list != nil {
+			return false
+		}
+		configured = true
+	}
+	return configured
+}
+
+// setFallbackActive records whether the fallback list with the given number
+// of entries is in use. A count of zero means it is not used.
+func (u *ListUpdater) setFallbackActive(entries int) {
+	active := entries > 0
+	if active == u.fallbackActive {
+		return
+	}
+	u.fallbackActive = active
+	if active {
+		log.Warningf("No blacklist has been loaded yet, using the embedded fallback list with %d entries", entries)
+		fallbackListActive.Inc()
+	} else {
+		log.Info("Blacklists have been loaded, the embedded fallback list is no longer used")
+		fallbackListActive.Dec()
+	}
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFallbackList(t *testing.T) {
+	var available int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&available) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("0.0.0.0 ads.example.com\n"))
+	}))
+	defer server.Close()
+
+	p := initTestPlugin(t, getEmptyRuleset())
+	p.config.BlacklistURLs = []string{server.URL + "/list.txt"}
+	p.updater = &ListUpdater{
+		Enabled:        true,
+		Fallback:       true,
+		Plugin:         p,
+		UpdateInterval: time.Second,
+		RetryCount:     1,
+		RetryDelay:     100 * time.Millisecond,
+	}
+	p.updater.Start()
+	defer p.updater.Stop()
+
+	// The fallback list is active right away
+	assert.True(t, p.ShouldBlock("doubleclick.net"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(fallbackListActive))
+
+	time.Sleep(500 * time.Millisecond)
+	assert.True(t, p.ShouldBlock("doubleclick.net"))
+
+	atomic.StoreInt32(&available, 1)
+	waitForLists(t, p.updater, 5*time.Second, func(s *listSnapshot) bool {
+		return s.http.Blacklist["ads.example.com"]
+	})
+	assert.True(t, p.ShouldBlock("ads.example.com"))
+	assert.False(t, p.ShouldBlock("doubleclick.net"))
+	assert.Equal(t, 0.0, testutil.ToFloat64(fallbackListActive))
+}
+
+func TestFallbackList_Embedded(t *testing.T) {
+	list := fallbackList()
+	assert.True(t, len(list) > 50)
+	assert.True(t, list["doubleclick.net"])
+}
+
+func TestSetup_DisableFallbackList(t *testing.T) {
+	c := caddy.NewTestController("dns", "ads {\n disable-fallback-list\n}")
+	c.Next()
+	cfg, err := parsePluginConfiguration(c)
+	assert.NoError(t, err)
+	assert.False(t, cfg.EnableFallbackList)
+	assert.True(t, defaultConfigWithoutRules.EnableFallbackList)
+}
@@ -0,0 +1,231 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fileChangeDebounce is the time to wait for further events before a
+// change gets applied. Editors usually emit several events when saving.
+const fileChangeDebounce = 100 * time.Millisecond
+
+// watchFiles sends a notification whenever a file matching one of the given
+// sources changes. A source is either a file, a directory or a glob pattern.
+// The parent directories of files are watched instead of the files
+// themselves, since editors and configuration management tools usually
+// replace files instead of writing them in place.
+func watchFiles(ctx context.Context, sources []string) (<-chan struct{}, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	directories := make(map[string]bool)
+	for _, source := range sources {
+		source = filepath.Clean(source)
+		if info, err := os.Stat(source); err == nil && info.IsDir() {
+			directories[source] = true
+		} else {
+			directories[filepath.Dir(source)] = true
+		}
+	}
+
+	for dir := range directories {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+
+	matches := func(name string) bool {
+		name = filepath.Clean(name)
+		for _, source := range sources {
+			source = filepath.Clean(source)
+			if source == name || source == filepath.Dir(name) && isListFile(name) {
+				return true
+			}
+			if ok, _ := filepath.Match(source, name); ok && isListFile(name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer watcher.Close()
+
+		var debounce <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if matches(event.Name) {
+					debounce = time.After(fileChangeDebounce)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Warningf("Watching list files failed: %s", err.Error())
+			case <-debounce:
+				debounce = nil
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return changes, nil
+}
+
+// isFileSourceCollection returns true if the source is a directory or a glob
+// pattern, i.e. it may resolve to multiple files.
+func isFileSourceCollection(source string) bool {
+	if strings.ContainsAny(source, "*?[") {
+		return true
+	}
+	info, err := os.Stat(source)
+	return err == nil && info.IsDir()
+}
+
+// expandFileSource returns the files of a source. Directories resolve to all
+// list files they contain, glob patterns to all matching list files. Plain
+// paths are returned as they are, even if the file does not exist.
+func expandFileSource(source string) ([]string, error) {
+	if strings.ContainsAny(source, "*?[") {
+		matches, err := filepath.Glob(source)
+		if err != nil {
+			return nil, err
+		}
+		return filterListFiles(matches), nil
+	}
+
+	info, err := os.Stat(source)
+	if err != nil || !info.IsDir() {
+		return []string{source}, nil
+	}
+
+	entries, err := ioutil.ReadDir(source)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0)
+	for _, entry := range entries {
+		paths = append(paths, filepath.Join(source, entry.Name()))
+	}
+	return filterListFiles(paths), nil
+}
+
+func filterListFiles(paths []string) []string {
+	files := make([]string, 0)
+	for _, path := range paths {
+		if !isListFile(path) {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			files = append(files, path)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// isListFile skips hidden files, editor backups and signatures, which might
+// be placed next to the lists.
+func isListFile(path string) bool {
+	name := filepath.Base(path)
+	return !strings.HasPrefix(name, ".") && !strings.HasSuffix(name, "~") && filepath.Ext(name) != ".minisig"
+}
+
+// fileSourceState tracks a local list. The list only gets parsed again if
+// its content has changed, which is detected using the modification time
+// and size of the file and, if these differ, the hash of its content.
+type fileSourceState struct {
+	Path string
+	Kind listKind
+	// Source is the configured path, directory or glob pattern
+	Source string
+
+	modTime time.Time
+	size    int64
+	hash    [sha256.Size]byte
+	list    ListMap
+}
+
+// refresh reloads the list if the file has changed. It returns true if the
+// entries of the list have changed.
+func (s *fileSourceState) refresh(ctx context.Context, f *ListFetcher) (bool, error) {
+	info, err := os.Stat(s.Path)
+	if err != nil {
+		if s.list == nil {
+			return false, err
+		}
+		// Drop the entries of removed files
+		*s = fileSourceState{Path: s.Path, Kind: s.Kind, Source: s.Source}
+		return true, err
+	}
+
+	if s.list != nil && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return false, nil
+	}
+
+	raw, err := f.fetchFile(ctx, s.Path)
+	if err != nil {
+		return false, err
+	}
+
+	hash := sha256.Sum256(raw.Data)
+	if s.list != nil && hash == s.hash {
+		s.modTime, s.size = info.ModTime(), info.Size()
+		return false, nil
+	}
+
+	fetchRaw := func(ctx context.Context, ref string) (*fetchedList, error) {
+		return raw, nil
+	}
+	var list ListMap
+	if s.Kind == listKindNetworks {
+		list, err = f.FetchNetworkList(ctx, s.Path, fetchRaw)
+	} else {
+		list, err = f.FetchList(ctx, s.Path, fetchRaw)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	s.modTime, s.size = info.ModTime(), info.Size()
+	s.hash = hash
+	s.list = list
+	return true, nil
+}
@@ -0,0 +1,204 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Flaque/filet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileWatcher_Reload(t *testing.T) {
+	tmpdir := filet.TmpDir(t, "")
+	defer filet.CleanUp(t)
+
+	blacklistPath := filepath.Join(tmpdir, "blacklist.txt")
+	assert.NoError(t, ioutil.WriteFile(blacklistPath, []byte("0.0.0.0 first.example.com\n"), 0644))
+
+	p := initTestPlugin(t, getEmptyRuleset())
+	p.config.BlacklistURLs = []string{}
+	p.config.BlacklistFiles = []string{blacklistPath}
+	p.config.FileListRenewalInterval = time.Hour
+
+	p.updater = &ListUpdater{Plugin: p, UpdateInterval: time.Hour, RetryCount: 1}
+	p.updater.Start()
+	defer p.updater.Stop()
+
+	waitForLists(t, p.updater, 5*time.Second, func(s *listSnapshot) bool {
+		return s.files.Blacklist["first.example.com"]
+	})
+	assert.True(t, p.IsBlacklisted("first.example.com"))
+
+	// Replace the file like editors do
+	tmpPath := filepath.Join(tmpdir, ".blacklist.txt.swp")
+	assert.NoError(t, ioutil.WriteFile(tmpPath, []byte("0.0.0.0 second.example.com\n"), 0644))
+	assert.NoError(t, os.Rename(tmpPath, blacklistPath))
+
+	waitForLists(t, p.updater, 5*time.Second, func(s *listSnapshot) bool {
+		return s.files.Blacklist["second.example.com"]
+	})
+	assert.False(t, p.IsBlacklisted("first.example.com"))
+	assert.True(t, p.IsBlacklisted("second.example.com"))
+
+	// Removed lists drop their entries
+	assert.NoError(t, os.Remove(blacklistPath))
+
+	waitForLists(t, p.updater, 5*time.Second, func(s *listSnapshot) bool {
+		return !s.files.Blacklist["second.example.com"]
+	})
+	assert.False(t, p.IsBlacklisted("second.example.com"))
+}
+
+func TestFileSourceState_ChangeDetection(t *testing.T) {
+	tmpdir := filet.TmpDir(t, "")
+	defer filet.CleanUp(t)
+
+	path := filepath.Join(tmpdir, "list.txt")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("0.0.0.0 ads.example.com\n"), 0644))
+
+	ctx := context.Background()
+	fetcher := &ListFetcher{}
+	s := &fileSourceState{Path: path}
+
+	changed, err := s.refresh(ctx, fetcher)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	list := s.list
+
+	changed, err = s.refresh(ctx, fetcher)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// Touching the file does not cause the list to be parsed again
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(path, later, later))
+	changed, err = s.refresh(ctx, fetcher)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, reflect.ValueOf(list).Pointer(), reflect.ValueOf(s.list).Pointer())
+	assert.Equal(t, later.Unix(), s.modTime.Unix())
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("0.0.0.0 tracker.example.com\n"), 0644))
+	changed, err = s.refresh(ctx, fetcher)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, ListMap{"tracker.example.com": true}, s.list)
+
+	assert.NoError(t, os.Remove(path))
+	changed, err = s.refresh(ctx, fetcher)
+	assert.Error(t, err)
+	assert.True(t, changed)
+	assert.Nil(t, s.list)
+}
+
+func TestFileWatcher_Notifications(t *testing.T) {
+	tmpdir := filet.TmpDir(t, "")
+	defer filet.CleanUp(t)
+
+	path := filepath.Join(tmpdir, "list.txt")
+	other := filepath.Join(tmpdir, "other.txt")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes, err := watchFiles(ctx, []string{path})
+	assert.NoError(t, err)
+
+	// Changes of other files in the directory are ignored
+	assert.NoError(t, ioutil.WriteFile(other, []byte("0.0.0.0 ads.example.com\n"), 0644))
+	select {
+	case <-changes:
+		t.Error("Unexpected notification for unwatched file")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("0.0.0.0 ads.example.com\n"), 0644))
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Error("Missing notification for changed file")
+	}
+}
+
+func TestFileWatcher_Directory(t *testing.T) {
+	tmpdir := filet.TmpDir(t, "")
+	defer filet.CleanUp(t)
+
+	firstPath := filepath.Join(tmpdir, "first.txt")
+	assert.NoError(t, ioutil.WriteFile(firstPath, []byte("0.0.0.0 first.example.com\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpdir, "first.txt~"), []byte("0.0.0.0 backup.example.com\n"), 0644))
+
+	p := initTestPlugin(t, getEmptyRuleset())
+	p.config.BlacklistURLs = []string{}
+	p.config.BlacklistFiles = []string{tmpdir}
+	p.config.FileListRenewalInterval = time.Hour
+
+	p.updater = &ListUpdater{Plugin: p, UpdateInterval: time.Hour, RetryCount: 1}
+	p.updater.Start()
+	defer p.updater.Stop()
+
+	waitForLists(t, p.updater, 5*time.Second, func(s *listSnapshot) bool {
+		return s.files.Blacklist["first.example.com"]
+	})
+	assert.True(t, p.IsBlacklisted("first.example.com"))
+	assert.False(t, p.IsBlacklisted("backup.example.com"))
+	assert.Equal(t, []string{"file://" + firstPath}, p.updater.Provenance("first.example.com", listKindBlacklist))
+
+	// New files get picked up
+	secondPath := filepath.Join(tmpdir, "second.txt")
+	assert.NoError(t, ioutil.WriteFile(secondPath, []byte("0.0.0.0 second.example.com\n"), 0644))
+
+	waitForLists(t, p.updater, 5*time.Second, func(s *listSnapshot) bool {
+		return s.files.Blacklist["second.example.com"]
+	})
+	assert.True(t, p.IsBlacklisted("second.example.com"))
+	assert.Equal(t, []string{"file://" + secondPath}, p.updater.Provenance("second.example.com", listKindBlacklist))
+
+	// Removed files drop their entries
+	assert.NoError(t, os.Remove(firstPath))
+
+	waitForLists(t, p.updater, 5*time.Second, func(s *listSnapshot) bool {
+		return !s.files.Blacklist["first.example.com"]
+	})
+	assert.False(t, p.IsBlacklisted("first.example.com"))
+	assert.True(t, p.IsBlacklisted("second.example.com"))
+}
+
+func TestExpandFileSource_Glob(t *testing.T) {
+	tmpdir := filet.TmpDir(t, "")
+	defer filet.CleanUp(t)
+
+	for _, name := range []string{"a.txt", "b.txt", "c.conf", ".hidden.txt"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpdir, name), []byte{}, 0644))
+	}
+	assert.NoError(t, os.Mkdir(filepath.Join(tmpdir, "dir.txt"), 0755))
+
+	files, err := expandFileSource(filepath.Join(tmpdir, "*.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(tmpdir, "a.txt"), filepath.Join(tmpdir, "b.txt")}, files)
+
+	files, err = expandFileSource(filepath.Join(tmpdir, "missing.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(tmpdir, "missing.txt")}, files)
+}
@@ -0,0 +1,249 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/coredns/caddy"
+)
+
+// httpClientConfig holds the options used to download lists from HTTP
+// sources. It is either shared by all sources (the `http` block) or
+// attached to a single `blacklist`/`whitelist` source.
+type httpClientConfig struct {
+	Proxy   *url.URL
+	Headers http.Header
+
+	BasicAuthUser     string
+	BasicAuthPassword secretRef
+	BearerToken       secretRef
+
+	CAFile         string
+	ClientCertFile string
+	ClientKeyFile  string
+}
+
+// secretRef references a secret stored in a file (`file:<PATH>`) or an
+// environment variable (`env:<NAME>`). Secrets get resolved on every
+// request so rotated credentials are picked up without a reload.
+type secretRef string
+
+func parseSecretRef(v string) (secretRef, error) {
+	if strings.HasPrefix(v, "file:") && len(v) > len("file:") ||
+		strings.HasPrefix(v, "env:") && len(v) > len("env:") {
+		return secretRef(v), nil
+	}
+	return "", fmt.Errorf("invalid secret %q, expected file:<PATH> or env:<NAME>", v)
+}
+
+func (s secretRef) resolve() (string, error) {
+	v := string(s)
+	switch {
+	case strings.HasPrefix(v, "file:"):
+		data, err := ioutil.ReadFile(strings.TrimPrefix(v, "file:"))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	case strings.HasPrefix(v, "env:"):
+		name := strings.TrimPrefix(v, "env:")
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %q is not set", name)
+		}
+		return value, nil
+	}
+	return "", nil
+}
+
+// merge returns a copy of the configuration where all options set in
+// override replace the options of h. Headers of both get combined.
+func (h *httpClientConfig) merge(override *httpClientConfig) *httpClientConfig {
+	merged := &httpClientConfig{Headers: make(http.Header)}
+	for _, cfg := range []*httpClientConfig{h, override} {
+		if cfg == nil {
+			continue
+		}
+		if cfg.Proxy != nil {
+			merged.Proxy = cfg.Proxy
+		}
+		for k, v := range cfg.Headers {
+			merged.Headers[k] = v
+		}
+		if cfg.BasicAuthUser != "" {
+			merged.BasicAuthUser = cfg.BasicAuthUser
+			merged.BasicAuthPassword = cfg.BasicAuthPassword
+		}
+		if cfg.BearerToken != "" {
+			merged.BearerToken = cfg.BearerToken
+		}
+		if cfg.CAFile != "" {
+			merged.CAFile = cfg.CAFile
+		}
+		if cfg.ClientCertFile != "" {
+			merged.ClientCertFile = cfg.ClientCertFile
+			merged.ClientKeyFile = cfg.ClientKeyFile
+		}
+	}
+	return merged
+}
+
+func (h *httpClientConfig) buildClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if h.Proxy != nil {
+		transport.Proxy = http.ProxyURL(h.Proxy)
+	}
+
+	if h.CAFile != "" || h.ClientCertFile != "" {
+		tlsConfig := &tls.Config{}
+		if h.CAFile != "" {
+			pem, err := ioutil.ReadFile(h.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			pool, err := x509.SystemCertPool()
+			if err != nil || pool == nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in CA bundle %q", h.CAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		if h.ClientCertFile != "" {
+			cert, err := tls.LoadX509KeyPair(h.ClientCertFile, h.ClientKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// httpListClient downloads lists using a client built from a
+// httpClientConfig.
+type httpListClient struct {
+	Client *http.Client
+	Config *httpClientConfig
+}
+
+func newHTTPListClient(cfg *httpClientConfig) (*httpListClient, error) {
+	client, err := cfg.buildClient()
+	if err != nil {
+		return nil, err
+	}
+	return &httpListClient{Client: client, Config: cfg}, nil
+}
+
+func (h *httpListClient) newRequest(ctx context.Context, u string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range h.Config.Headers {
+		req.Header[k] = v
+	}
+
+	if h.Config.BasicAuthUser != "" {
+		password, err := h.Config.BasicAuthPassword.resolve()
+		if err != nil {
+			return nil, err
+		}
+		req.SetBasicAuth(h.Config.BasicAuthUser, password)
+	}
+
+	if h.Config.BearerToken != "" {
+		token, err := h.Config.BearerToken.resolve()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return req, nil
+}
+
+// parseHTTPClientOption parses a single option of a `http` block or a list
+// source block. It returns false if the current token is not a HTTP option.
+func parseHTTPClientOption(c *caddy.Controller, cfg *httpClientConfig) (bool, error) {
+	switch c.Val() {
+	case "proxy":
+		if !c.NextArg() {
+			return true, c.Err("No proxy URL defined")
+		}
+		proxyUrl, err := url.Parse(c.Val())
+		if err != nil || proxyUrl.Scheme == "" || proxyUrl.Host == "" {
+			return true, c.Errf("Invalid proxy URL %q", c.Val())
+		}
+		cfg.Proxy = proxyUrl
+	case "header":
+		args := c.RemainingArgs()
+		if len(args) != 2 {
+			return true, c.Err("A header has to be defined as 'header <NAME> <VALUE>'")
+		}
+		cfg.Headers.Add(args[0], args[1])
+	case "basic-auth":
+		args := c.RemainingArgs()
+		if len(args) != 2 {
+			return true, c.Err("Basic auth has to be defined as 'basic-auth <USER> <SECRET>'")
+		}
+		secret, err := parseSecretRef(args[1])
+		if err != nil {
+			return true, c.Err(err.Error())
+		}
+		cfg.BasicAuthUser = args[0]
+		cfg.BasicAuthPassword = secret
+	case "bearer-token":
+		if !c.NextArg() {
+			return true, c.Err("No secret for the bearer token defined")
+		}
+		secret, err := parseSecretRef(c.Val())
+		if err != nil {
+			return true, c.Err(err.Error())
+		}
+		cfg.BearerToken = secret
+	case "ca":
+		if !c.NextArg() {
+			return true, c.Err("No CA bundle defined")
+		}
+		cfg.CAFile = c.Val()
+	case "client-cert":
+		args := c.RemainingArgs()
+		if len(args) != 2 {
+			return true, c.Err("A client certificate has to be defined as 'client-cert <CERT FILE> <KEY FILE>'")
+		}
+		cfg.ClientCertFile = args[0]
+		cfg.ClientKeyFile = args[1]
+	default:
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Flaque/filet"
+	"github.com/coredns/caddy"
+	"github.com/stretchr/testify/assert"
+)
+
+const valid_HTTP_Corefile = `ads {
+  http {
+    proxy http://proxy.local:3128
+    header X-Client coredns
+  }
+  blacklist https://lists.local/list.txt {
+    bearer-token env:ADS_TEST_TOKEN
+    header X-List internal
+  }
+  blacklist https://lists.local/other.txt {
+    basic-auth ads file:/etc/coredns/ads.secret
+  }
+}`
+const invalid_HTTP_Secret_Corefile = `ads {
+  blacklist https://lists.local/list.txt {
+    bearer-token my-plain-token
+  }
+}`
+const invalid_HTTP_FileOption_Corefile = `ads {
+  blacklist file:///etc/coredns/list.txt {
+    proxy http://proxy.local:3128
+  }
+}`
+const invalid_HTTP_UnknownOption_Corefile = `ads {
+  http {
+    proxi http://proxy.local:3128
+  }
+}`
+
+func TestSetup_HTTPClientOptions(t *testing.T) {
+	c := caddy.NewTestController("dns", valid_HTTP_Corefile)
+	c.Next()
+	cfg, err := parsePluginConfiguration(c)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "proxy.local:3128", cfg.HTTPClient.Proxy.Host)
+	assert.Equal(t, "coredns", cfg.HTTPClient.Headers.Get("X-Client"))
+
+	source := cfg.ListSources["https://lists.local/list.txt"]
+	assert.Equal(t, secretRef("env:ADS_TEST_TOKEN"), source.HTTP.BearerToken)
+
+	merged := cfg.HTTPClient.merge(source.HTTP)
+	assert.Equal(t, "coredns", merged.Headers.Get("X-Client"))
+	assert.Equal(t, "internal", merged.Headers.Get("X-List"))
+	assert.Equal(t, "proxy.local:3128", merged.Proxy.Host)
+
+	other := cfg.ListSources["https://lists.local/other.txt"]
+	assert.Equal(t, "ads", other.HTTP.BasicAuthUser)
+	assert.Equal(t, secretRef("file:/etc/coredns/ads.secret"), other.HTTP.BasicAuthPassword)
+
+	for _, v := range []string{invalid_HTTP_Secret_Corefile, invalid_HTTP_FileOption_Corefile, invalid_HTTP_UnknownOption_Corefile} {
+		c := caddy.NewTestController("dns", v)
+		c.Next()
+		_, err := parsePluginConfiguration(c)
+		assert.Error(t, err)
+	}
+}
+
+func TestHTTPClient_Auth(t *testing.T) {
+	tmpdir := filet.TmpDir(t, "")
+	defer filet.CleanUp(t)
+
+	passwordFile := filepath.Join(tmpdir, "password")
+	assert.NoError(t, ioutil.WriteFile(passwordFile, []byte("s3cret\n"), 0600))
+	os.Setenv("ADS_TEST_TOKEN", "t0ken")
+	defer os.Unsetenv("ADS_TEST_TOKEN")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		user, password, ok := req.BasicAuth()
+		switch {
+		case req.URL.Path == "/basic" && ok && user == "ads" && password == "s3cret":
+		case req.URL.Path == "/bearer" && req.Header.Get("Authorization") == "Bearer t0ken":
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if req.Header.Get("X-Client") != "coredns" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "0.0.0.0 %s.example.com\n", req.URL.Path[1:])
+	}))
+	defer srv.Close()
+
+	shared := &httpClientConfig{Headers: http.Header{"X-Client": {"coredns"}}}
+	basic, err := newHTTPListClient(shared.merge(&httpClientConfig{BasicAuthUser: "ads", BasicAuthPassword: secretRef("file:" + passwordFile)}))
+	assert.NoError(t, err)
+	bearer, err := newHTTPListClient(shared.merge(&httpClientConfig{BearerToken: "env:ADS_TEST_TOKEN"}))
+	assert.NoError(t, err)
+
+	fetcher := &ListFetcher{
+		Workers: 2,
+		Clients: map[string]*httpListClient{
+			srv.URL + "/basic":  basic,
+			srv.URL + "/bearer": bearer,
+		},
+	}
+
+	list, err := fetcher.GenerateListMapFromHTTPUrls(context.Background(), []string{srv.URL + "/basic", srv.URL + "/bearer", srv.URL + "/none"})
+	assert.NoError(t, err)
+	assert.Equal(t, ListMap{"basic.example.com": true, "bearer.example.com": true}, list)
+}
+
+func TestHTTPClient_Proxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Proxied requests carry the absolute URL of the list
+		fmt.Fprintf(w, "0.0.0.0 %s\n", req.URL.Hostname())
+	}))
+	defer proxy.Close()
+
+	cfg := &httpClientConfig{Headers: make(http.Header)}
+	c := caddy.NewTestController("dns", "proxy "+proxy.URL)
+	c.Next()
+	ok, err := parseHTTPClientOption(c, cfg)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	client, err := newHTTPListClient(cfg)
+	assert.NoError(t, err)
+
+	fetcher := &ListFetcher{Workers: 1, Clients: map[string]*httpListClient{"http://lists.example.org/list.txt": client}}
+	list, err := fetcher.GenerateListMapFromHTTPUrls(context.Background(), []string{"http://lists.example.org/list.txt"})
+	assert.NoError(t, err)
+	assert.True(t, list["lists.example.org"])
+}
+
+func TestHTTPClient_CustomCA(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintln(w, "0.0.0.0 internal.example.com")
+	}))
+	defer srv.Close()
+
+	tmpdir := filet.TmpDir(t, "")
+	defer filet.CleanUp(t)
+
+	caFile := filepath.Join(tmpdir, "ca.pem")
+	caData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	assert.NoError(t, ioutil.WriteFile(caFile, caData, 0600))
+
+	untrusted := &ListFetcher{Workers: 1}
+	list, err := untrusted.GenerateListMapFromHTTPUrls(context.Background(), []string{srv.URL})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(list))
+
+	client, err := newHTTPListClient(&httpClientConfig{CAFile: caFile})
+	assert.NoError(t, err)
+
+	trusted := &ListFetcher{Workers: 1, Clients: map[string]*httpListClient{srv.URL: client}}
+	list, err = trusted.GenerateListMapFromHTTPUrls(context.Background(), []string{srv.URL})
+	assert.NoError(t, err)
+	assert.True(t, list["internal.example.com"])
+
+	_, err = newHTTPListClient(&httpClientConfig{CAFile: filepath.Join(tmpdir, "missing.pem")})
+	assert.Error(t, err)
+}
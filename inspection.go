@@ -0,0 +1,96 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"strings"
+
+	"github.com/coredns/caddy"
+	"github.com/miekg/dns"
+)
+
+// inspectableTypes contains the record types whose names get checked against
+// the lists if they are part of an answer.
+var inspectableTypes = map[string]uint16{
+	"a":     dns.TypeA,
+	"aaaa":  dns.TypeAAAA,
+	"cname": dns.TypeCNAME,
+	"dname": dns.TypeDNAME,
+	"svcb":  dns.TypeSVCB,
+	"https": dns.TypeHTTPS,
+	"mx":    dns.TypeMX,
+	"srv":   dns.TypeSRV,
+}
+
+// inspects returns true if answers of the given type should be inspected.
+func (e *DNSAdBlock) inspects(rrtype uint16) bool {
+	return !e.config.DisabledInspections[rrtype]
+}
+
+// inspectedNames returns the names of a record that get checked against the
+// lists, i.e. its owner name and the name it points to. Records of types that
+// are not inspected return no names.
+func inspectedNames(rr dns.RR) []string {
+	names := []string{rr.Header().Name}
+	switch v := rr.(type) {
+	case *dns.A, *dns.AAAA:
+	case *dns.CNAME:
+		names = append(names, v.Target)
+	case *dns.DNAME:
+		names = append(names, v.Target)
+	case *dns.SVCB:
+		names = append(names, v.Target)
+	case *dns.HTTPS:
+		names = append(names, v.Target)
+	case *dns.MX:
+		names = append(names, v.Mx)
+	case *dns.SRV:
+		names = append(names, v.Target)
+	default:
+		return nil
+	}
+
+	trimmed := make([]string, 0, len(names))
+	for _, name := range names {
+		// A target of "." refers to the owner name (SVCB) or no host at all (MX, SRV)
+		if name == "." {
+			continue
+		}
+		trimmed = append(trimmed, strings.TrimSuffix(strings.ToLower(name), "."))
+	}
+	return trimmed
+}
+
+// parseInspectOption parses a single line of the inspect block.
+func parseInspectOption(c *caddy.Controller, config *adsPluginConfig) error {
+	rrtype, ok := inspectableTypes[strings.ToLower(c.Val())]
+	if !ok {
+		return c.Errf("Unsupported record type %q for inspection", c.Val())
+	}
+	if !c.NextArg() {
+		return c.Errf("Missing on or off for inspection of %s records", c.Val())
+	}
+	switch c.Val() {
+	case "on":
+		delete(config.DisabledInspections, rrtype)
+	case "off":
+		config.DisabledInspections[rrtype] = true
+	default:
+		return c.Errf("Invalid value %q, expected on or off", c.Val())
+	}
+	return nil
+}
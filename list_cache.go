@@ -0,0 +1,179 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"reflect"
+	"sync"
+	"time"
+)
+
+// sharedLists holds the HTTP lists of all plugin instances, i.e. of all
+// server blocks and of the configurations before and after a reload. Lists
+// loaded with the same options are downloaded and stored only once. Every
+// list is reference counted and dropped once no instance uses it anymore.
+var sharedLists = &listCache{entries: make(map[string][]*sharedList)}
+
+type listCache struct {
+	mutex   sync.Mutex
+	entries map[string][]*sharedList
+}
+
+type sharedList struct {
+	options listContentOptions
+	refs    int
+
+	// fetching prevents instances from downloading the list concurrently.
+	// It holds a token while a download is running, so waiting for it can
+	// be aborted.
+	fetching   chan struct{}
+	mutex      sync.Mutex
+	list       ListMap
+	lastUpdate time.Time
+}
+
+// listContentOptions are the options of a source affecting the content of
+// the loaded list.
+type listContentOptions struct {
+	ArchiveMember string
+	Verification  *listVerification
+	HTTP          *httpClientConfig
+}
+
+func (c *listCache) acquire(key string, options listContentOptions) *sharedList {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, e := range c.entries[key] {
+		if reflect.DeepEqual(e.options, options) {
+			e.refs++
+			return e
+		}
+	}
+	e := &sharedList{options: options, refs: 1, fetching: make(chan struct{}, 1)}
+	c.entries[key] = append(c.entries[key], e)
+	return e
+}
+
+func (c *listCache) release(key string, e *sharedList) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e.refs--
+	if e.refs > 0 {
+		return
+	}
+	entries := c.entries[key]
+	for i, v := range entries {
+		if v == e {
+			entries = append(entries[:i], entries[i+1:]...)
+			break
+		}
+	}
+	if len(entries) == 0 {
+		delete(c.entries, key)
+	} else {
+		c.entries[key] = entries
+	}
+}
+
+func (e *sharedList) get() (ListMap, time.Time) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.list, e.lastUpdate
+}
+
+func (e *sharedList) set(list ListMap, lastUpdate time.Time) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.list == nil || lastUpdate.After(e.lastUpdate) {
+		e.list, e.lastUpdate = list, lastUpdate
+	}
+}
+
+func (u *ListUpdater) contentOptions(listUrl string) listContentOptions {
+	options := listContentOptions{HTTP: u.Plugin.config.HTTPClient.merge(nil)}
+	if source := u.Plugin.config.ListSources[listUrl]; source != nil {
+		options.ArchiveMember = source.ArchiveMember
+		options.Verification = source.Verification
+		options.HTTP = u.Plugin.config.HTTPClient.merge(source.HTTP)
+	}
+	return options
+}
+
+// acquireSharedLists registers the sources of the updater in the list cache.
+// Lists that have already been loaded by another instance are used right
+// away. It returns the number of lists loaded from the cache.
+func (u *ListUpdater) acquireSharedLists() int {
+	u.sourceMutex.Lock()
+	defer u.sourceMutex.Unlock()
+
+	n := 0
+	for _, s := range u.sources {
+		s.shared = sharedLists.acquire(sourceKey(s.URL, s.Kind), u.contentOptions(s.URL))
+		if list, lastUpdate := s.shared.get(); list != nil {
+			s.list, s.lastUpdate = list, lastUpdate
+			n++
+		}
+	}
+	if n > 0 {
+		log.Infof("Loaded %d lists already used by other configurations", n)
+	}
+	return n
+}
+
+func (u *ListUpdater) releaseSharedLists() {
+	u.sourceMutex.Lock()
+	defer u.sourceMutex.Unlock()
+	for _, s := range u.sources {
+		if s.shared != nil {
+			sharedLists.release(sourceKey(s.URL, s.Kind), s.shared)
+			s.shared = nil
+		}
+	}
+}
+
+// loadSource downloads the list of a source, unless another instance has
+// loaded a newer version of the list in the meantime. It returns the list
+// and the time of the download.
+func (u *ListUpdater) loadSource(s *listSourceState, lastUpdate time.Time) (ListMap, time.Time, error) {
+	shared := s.shared
+	if shared == nil {
+		list, err := u.fetchSource(s)
+		return list, time.Now(), err
+	}
+
+	// Stopped instances must not wait for the download of another instance
+	select {
+	case shared.fetching <- struct{}{}:
+	case <-u.ctx.Done():
+		return nil, time.Time{}, u.ctx.Err()
+	}
+	defer func() { <-shared.fetching }()
+	if err := u.ctx.Err(); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if list, sharedUpdate := shared.get(); list != nil && sharedUpdate.After(lastUpdate) {
+		return list, sharedUpdate, nil
+	}
+	list, err := u.fetchSource(s)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	now := time.Now()
+	shared.set(list, now)
+	return list, now, nil
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func listCountingServer(t *testing.T) (*httptest.Server, func(path string) int) {
+	var mutex sync.Mutex
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests[r.URL.Path]++
+		mutex.Unlock()
+		fmt.Fprintf(w, "0.0.0.0 %s.example.com\n", r.URL.Path[1:])
+	}))
+	return server, func(path string) int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return requests[path]
+	}
+}
+
+func startTestUpdater(t *testing.T, urls ...string) *DNSAdBlock {
+	p := initTestPlugin(t, getEmptyRuleset())
+	p.config.BlacklistURLs = urls
+	p.config.ListSources = make(map[string]*listSourceConfig)
+	p.updater = &ListUpdater{Enabled: true, Plugin: p, UpdateInterval: time.Hour, RetryCount: 1}
+	p.updater.Start()
+	assert.Eventually(t, p.Ready, 5*time.Second, 50*time.Millisecond)
+	return p
+}
+
+func TestListCache_MultipleConfigurations(t *testing.T) {
+	server, count := listCountingServer(t)
+	defer server.Close()
+
+	guest := startTestUpdater(t, server.URL+"/ads", server.URL+"/adult")
+	corp := startTestUpdater(t, server.URL+"/ads", server.URL+"/malware")
+	defer guest.updater.Stop()
+	defer corp.updater.Stop()
+
+	assert.True(t, guest.ShouldBlock("adult.example.com"))
+	assert.False(t, guest.ShouldBlock("malware.example.com"))
+	assert.True(t, corp.ShouldBlock("malware.example.com"))
+	assert.False(t, corp.ShouldBlock("adult.example.com"))
+	assert.True(t, guest.ShouldBlock("ads.example.com"))
+	assert.True(t, corp.ShouldBlock("ads.example.com"))
+	assert.Equal(t, 1, count("/ads"))
+}
+
+func TestListCache_Reload(t *testing.T) {
+	server, count := listCountingServer(t)
+	defer server.Close()
+
+	old := startTestUpdater(t, server.URL+"/first")
+	assert.Equal(t, 1, count("/first"))
+
+	// On a reload, the new instance starts before the old one is shut down
+	p := startTestUpdater(t, server.URL+"/first", server.URL+"/second")
+	old.updater.Stop()
+	assert.Error(t, old.updater.ctx.Err())
+
+	assert.True(t, p.ShouldBlock("first.example.com"))
+	assert.True(t, p.ShouldBlock("second.example.com"))
+	assert.Equal(t, 1, count("/first"))
+	assert.Equal(t, 1, count("/second"))
+	p.updater.Stop()
+
+	// Lists are dropped once they are no longer used
+	sharedLists.mutex.Lock()
+	assert.NotContains(t, sharedLists.entries, sourceKey(server.URL+"/first", listKindBlacklist))
+	assert.NotContains(t, sharedLists.entries, sourceKey(server.URL+"/second", listKindBlacklist))
+	sharedLists.mutex.Unlock()
+
+	p = startTestUpdater(t, server.URL+"/first")
+	assert.Equal(t, 2, count("/first"))
+	p.updater.Stop()
+}
+
+func TestListCache_Options(t *testing.T) {
+	cache := &listCache{entries: make(map[string][]*sharedList)}
+	key := sourceKey("https://example.com/list.zip", listKindBlacklist)
+
+	hosts := cache.acquire(key, listContentOptions{ArchiveMember: "hosts"})
+	assert.Equal(t, hosts, cache.acquire(key, listContentOptions{ArchiveMember: "hosts"}))
+	domains := cache.acquire(key, listContentOptions{ArchiveMember: "domains"})
+	assert.NotEqual(t, hosts, domains)
+	verified := cache.acquire(key, listContentOptions{ArchiveMember: "hosts", Verification: &listVerification{SHA256: []byte{1}}})
+	assert.NotEqual(t, hosts, verified)
+	assert.Len(t, cache.entries[key], 3)
+
+	cache.release(key, hosts)
+	assert.Len(t, cache.entries[key], 3)
+	cache.release(key, hosts)
+	cache.release(key, domains)
+	cache.release(key, verified)
+	assert.Empty(t, cache.entries)
+}
+
+func TestListCache_StopWhileWaiting(t *testing.T) {
+	shared := &sharedList{fetching: make(chan struct{}, 1)}
+	u := &ListUpdater{}
+	u.ctx, u.cancel = context.WithCancel(context.Background())
+
+	// Another instance is downloading the list
+	shared.fetching <- struct{}{}
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := u.loadSource(&listSourceState{shared: shared}, time.Time{})
+		done <- err
+	}()
+
+	u.cancel()
+	select {
+	case err := <-done:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("Stopped updater is still waiting for the download")
+	}
+}
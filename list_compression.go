@@ -0,0 +1,170 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	compressionNone  = ""
+	compressionGzip  = "gzip"
+	compressionBzip2 = "bzip2"
+	compressionXz    = "xz"
+	compressionZip   = "zip"
+)
+
+var compressionMagic = []struct {
+	format string
+	magic  []byte
+}{
+	{compressionGzip, []byte{0x1f, 0x8b}},
+	{compressionBzip2, []byte("BZh")},
+	{compressionXz, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{compressionZip, []byte("PK\x03\x04")},
+	{compressionZip, []byte("PK\x05\x06")},
+}
+
+var compressionContentTypes = map[string]string{
+	"application/gzip":             compressionGzip,
+	"application/x-gzip":           compressionGzip,
+	"application/x-bzip2":          compressionBzip2,
+	"application/x-xz":             compressionXz,
+	"application/zip":              compressionZip,
+	"application/x-zip":            compressionZip,
+	"application/x-zip-compressed": compressionZip,
+}
+
+var compressionExtensions = map[string]string{
+	".gz":  compressionGzip,
+	".bz2": compressionBzip2,
+	".xz":  compressionXz,
+	".zip": compressionZip,
+}
+
+// detectCompression determines the compression of a list using the
+// Content-Encoding and Content-Type headers and the file extension. The magic
+// bytes of the data are used to check the announced format, or to detect the
+// format if none is announced. Lists announced as compressed without being
+// compressed are used as they are, since list hosts frequently serve already
+// decompressed data for `.gz` URLs.
+func detectCompression(list *fetchedList) (string, error) {
+	announced := compressionHint(list)
+	actual := magicFormat(list.Data)
+	switch {
+	case announced == actual:
+		return announced, nil
+	case actual == compressionNone:
+		log.Debugf("List %q is announced as %s but is not compressed, parsing it as plain text", list.Name, announced)
+		return compressionNone, nil
+	case announced == compressionNone:
+		return actual, nil
+	}
+	return "", fmt.Errorf("list %q is announced as %s but contains %s compressed data", list.Name, announced, actual)
+}
+
+// magicFormat returns the compression format indicated by the magic bytes.
+func magicFormat(data []byte) string {
+	for _, m := range compressionMagic {
+		if bytes.HasPrefix(data, m.magic) {
+			return m.format
+		}
+	}
+	return compressionNone
+}
+
+func compressionHint(list *fetchedList) string {
+	switch strings.ToLower(list.ContentEncoding) {
+	case "gzip", "x-gzip":
+		return compressionGzip
+	}
+	if mediaType, _, err := mime.ParseMediaType(list.ContentType); err == nil {
+		if format, ok := compressionContentTypes[mediaType]; ok {
+			return format
+		}
+	}
+	return compressionExtensions[strings.ToLower(path.Ext(list.Name))]
+}
+
+// decompress unpacks compressed lists. For zip archives all members matching
+// archiveMember are concatenated, if no pattern is set all files in the
+// archive are used. The decompressed size is limited by MaxSize.
+func (f *ListFetcher) decompress(list *fetchedList, archiveMember string) ([]byte, error) {
+	format, err := detectCompression(list)
+	if err != nil {
+		return nil, err
+	}
+
+	var reader io.Reader
+	switch format {
+	case compressionNone:
+		return list.Data, nil
+	case compressionGzip:
+		return gunzipWith(list.Data, f.readLimited)
+	case compressionBzip2:
+		reader = bzip2.NewReader(bytes.NewReader(list.Data))
+	case compressionXz:
+		r, err := xz.NewReader(bytes.NewReader(list.Data))
+		if err != nil {
+			return nil, err
+		}
+		reader = r
+	case compressionZip:
+		return f.unzip(list, archiveMember)
+	}
+	return f.readLimited(reader)
+}
+
+func (f *ListFetcher) unzip(list *fetchedList, archiveMember string) ([]byte, error) {
+	archive, err := zip.NewReader(bytes.NewReader(list.Data), int64(len(list.Data)))
+	if err != nil {
+		return nil, err
+	}
+
+	readers := make([]io.Reader, 0)
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		if archiveMember != "" {
+			if ok, _ := path.Match(archiveMember, file.Name); !ok {
+				continue
+			}
+		}
+		r, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		// Members might not end with a line break
+		readers = append(readers, r, strings.NewReader("\n"))
+	}
+
+	if len(readers) == 0 {
+		return nil, fmt.Errorf("no member of archive %q matches %q", list.Name, archiveMember)
+	}
+	return f.readLimited(io.MultiReader(readers...))
+}
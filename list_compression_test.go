@@ -0,0 +1,176 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/Flaque/filet"
+	"github.com/stretchr/testify/assert"
+	"github.com/ulikunitz/xz"
+)
+
+const compressedHostlistPath = "testdata/update_hostlist_test_first_list.bz2"
+
+func TestCompression_Formats(t *testing.T) {
+	plain, err := ioutil.ReadFile(firstHostlistPath)
+	assert.NoError(t, err)
+
+	gzipped, err := gzip(plain)
+	assert.NoError(t, err)
+
+	bzipped, err := ioutil.ReadFile(compressedHostlistPath)
+	assert.NoError(t, err)
+
+	var xzBuffer bytes.Buffer
+	xzWriter, err := xz.NewWriter(&xzBuffer)
+	assert.NoError(t, err)
+	_, err = xzWriter.Write(plain)
+	assert.NoError(t, err)
+	assert.NoError(t, xzWriter.Close())
+
+	zipped := zipArchive(t, map[string][]byte{"hosts.txt": plain})
+
+	fetcher := &ListFetcher{}
+	for format, data := range map[string][]byte{
+		compressionNone:  plain,
+		compressionGzip:  gzipped,
+		compressionBzip2: bzipped,
+		compressionXz:    xzBuffer.Bytes(),
+		compressionZip:   zipped,
+	} {
+		list := &fetchedList{Data: data, Name: "list.txt"}
+		for ext, f := range compressionExtensions {
+			if f == format {
+				list.Name = "list" + ext
+			}
+		}
+		detected, err := detectCompression(list)
+		assert.NoError(t, err)
+		assert.Equal(t, format, detected)
+
+		decompressed, err := fetcher.decompress(list, "")
+		assert.NoError(t, err)
+		// Zip members get separated by an additional line break
+		assert.Equal(t, bytes.TrimSpace(plain), bytes.TrimSpace(decompressed))
+	}
+}
+
+func TestCompression_Hints(t *testing.T) {
+	assert.Equal(t, compressionGzip, compressionHint(&fetchedList{ContentEncoding: "gzip"}))
+	assert.Equal(t, compressionXz, compressionHint(&fetchedList{ContentType: "application/x-xz; charset=binary"}))
+	assert.Equal(t, compressionZip, compressionHint(&fetchedList{Name: "/lists/hosts.ZIP"}))
+	assert.Equal(t, compressionNone, compressionHint(&fetchedList{Name: "/lists/hosts.txt", ContentType: "text/plain"}))
+
+	// Data which is not compressed is used as it is regardless of the hints
+	plain := []byte("0.0.0.0 ads.example.com\n")
+	decompressed, err := (&ListFetcher{}).decompress(&fetchedList{Data: plain, Name: "hosts.gz", ContentType: "application/gzip"}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, plain, decompressed)
+
+	// Compressed data has to match the announced format
+	gzipped, err := gzip(plain)
+	assert.NoError(t, err)
+	_, err = detectCompression(&fetchedList{Data: gzipped, Name: "hosts.bz2"})
+	assert.Error(t, err)
+	_, err = detectCompression(&fetchedList{Data: gzipped, Name: "hosts.txt", ContentEncoding: "gzip"})
+	assert.NoError(t, err)
+
+	// Formats which are not announced are detected using the magic bytes
+	format, err := detectCompression(&fetchedList{Data: gzipped, Name: "/lists/hosts", ContentType: "application/octet-stream"})
+	assert.NoError(t, err)
+	assert.Equal(t, compressionGzip, format)
+}
+
+func TestCompression_ZipMembers(t *testing.T) {
+	zipped := zipArchive(t, map[string][]byte{
+		"ads/hosts.txt":     []byte("0.0.0.0 ads.example.com"),
+		"tracking/list.txt": []byte("0.0.0.0 tracker.example.com"),
+		"README":            []byte("read me"),
+	})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Write(zipped)
+	}))
+	defer srv.Close()
+
+	fetcher := &ListFetcher{
+		Workers: 1,
+		Lists: map[string]*listSourceConfig{
+			srv.URL + "/ads.zip":  {URL: srv.URL + "/ads.zip", ArchiveMember: "ads/*.txt"},
+			srv.URL + "/none.zip": {URL: srv.URL + "/none.zip", ArchiveMember: "*.csv"},
+		},
+	}
+
+	list, err := fetcher.GenerateListMapFromHTTPUrls(context.Background(), []string{srv.URL + "/ads.zip"})
+	assert.NoError(t, err)
+	assert.Equal(t, ListMap{"ads.example.com": true}, list)
+
+	list, err = fetcher.GenerateListMapFromHTTPUrls(context.Background(), []string{srv.URL + "/all.zip"})
+	assert.NoError(t, err)
+	assert.True(t, list["ads.example.com"])
+	assert.True(t, list["tracker.example.com"])
+
+	list, err = fetcher.GenerateListMapFromHTTPUrls(context.Background(), []string{srv.URL + "/none.zip"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(list))
+}
+
+func TestCompression_FileSource(t *testing.T) {
+	list, err := GenerateListMapFromFileUrls([]string{"file://" + compressedHostlistPath})
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, len(list))
+}
+
+func TestCompression_SizeLimit(t *testing.T) {
+	bomb, err := gzip(bytes.Repeat([]byte("0.0.0.0 ads.example.com\n"), 10000))
+	assert.NoError(t, err)
+
+	fetcher := &ListFetcher{MaxSize: 4096}
+	_, err = fetcher.decompress(&fetchedList{Data: bomb, Name: "bomb.gz"}, "")
+	assert.Equal(t, errListTooLarge, err)
+
+	tmpdir := filet.TmpDir(t, "")
+	defer filet.CleanUp(t)
+	path := filepath.Join(tmpdir, "bomb.gz")
+	assert.NoError(t, ioutil.WriteFile(path, bomb, 0600))
+
+	list, err := fetcher.GenerateListMapFromFileUrls(context.Background(), []string{path})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(list))
+}
+
+func zipArchive(t *testing.T, files map[string][]byte) []byte {
+	var buffer bytes.Buffer
+	w := zip.NewWriter(&buffer)
+	for name, data := range files {
+		f, err := w.Create(name)
+		assert.NoError(t, err)
+		_, err = f.Write(data)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+	return buffer.Bytes()
+}
@@ -17,69 +17,312 @@
 package ads
 
 import (
-	"golang.org/x/net/idna"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 	"unicode/utf8"
+
+	"golang.org/x/net/idna"
 )
 
 type ListMap map[string]bool
 
 var ValidateQName = regexp.MustCompile("([a-zA-Z0-9]|\\.|-)*").MatchString
 
+var errListTooLarge = errors.New("list exceeds the maximum allowed size")
+
+// fetchedList is the raw content of a list along with the metadata used to
+// detect its compression.
+type fetchedList struct {
+	Data            []byte
+	Name            string
+	ContentType     string
+	ContentEncoding string
+}
+
+type listFetchFunc func(ctx context.Context, ref string) (*fetchedList, error)
+
+// ListFetcher downloads lists concurrently. Every fetch is bounded by
+// Timeout and MaxSize, a value of zero disables the respective limit.
+// Sources with custom HTTP options are fetched with their entry in Clients,
+// all other sources use Client. The options of a list in Lists define how
+// it is verified and unpacked. Lists failing the verification are replaced
+// by their last verified version.
+type ListFetcher struct {
+	Workers int
+	Timeout time.Duration
+	MaxSize int64
+	Client  *http.Client
+	Clients map[string]*httpListClient
+	Lists   map[string]*listSourceConfig
+
+	verifiedMutex sync.Mutex
+	lastVerified  map[string][]byte
+}
+
+var defaultListFetcher = &ListFetcher{
+	Workers: defaultListFetchWorkers,
+	Timeout: defaultListFetchTimeout,
+	MaxSize: defaultListMaxSize,
+	Client:  http.DefaultClient,
+}
+
 func GenerateListMap(urls []string, fetchFunc func(ref string) ([]byte, error)) (ListMap, error) {
-	listMap := make(ListMap, 0)
-	for _, listUrl := range urls {
-		log.Debugf("Fetching list %q...", listUrl)
+	return defaultListFetcher.GenerateListMap(context.Background(), urls, func(ctx context.Context, ref string) (*fetchedList, error) {
+		data, err := fetchFunc(ref)
+		if err != nil {
+			return nil, err
+		}
+		return &fetchedList{Data: data, Name: ref}, nil
+	})
+}
+
+func GenerateListMapFromHTTPUrls(listUrls []string) (ListMap, error) {
+	return defaultListFetcher.GenerateListMapFromHTTPUrls(context.Background(), listUrls)
+}
+
+func GenerateListMapFromFileUrls(listUrls []string) (ListMap, error) {
+	return defaultListFetcher.GenerateListMapFromFileUrls(context.Background(), listUrls)
+}
+
+func (f *ListFetcher) GenerateListMap(ctx context.Context, urls []string, fetchFunc listFetchFunc) (ListMap, error) {
+	type fetchResult struct {
+		url  string
+		data []byte
+		err  error
+	}
+
+	workers := f.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(urls) {
+		workers = len(urls)
+	}
 
-		data, err := fetchFunc(listUrl)
+	jobs := make(chan string)
+	results := make(chan fetchResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for listUrl := range jobs {
+				log.Debugf("Fetching list %q...", listUrl)
+				data, err := f.fetchAndUnpack(ctx, listUrl, fetchFunc)
+				results <- fetchResult{url: listUrl, data: data, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for _, listUrl := range urls {
+			select {
+			case jobs <- listUrl:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	listMap := make(ListMap, 0)
+	for result := range results {
+		data, err := f.applyVerificationResult(result.url, result.data, result.err)
 		if err != nil {
-			log.Warningf("Loading list from url %q failed with error: %s", listUrl, err.Error())
+			log.Warningf("Loading list from url %q failed with error: %s", result.url, err.Error())
 			continue
 		}
 		parseListFile(data, listMap)
 	}
+
+	// Never hand out a partial list if the update has been cancelled
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	log.Debugf("Found %d unique domains in list", len(listMap))
 	return listMap, nil
 }
 
-func GenerateListMapFromHTTPUrls(listUrls []string) (ListMap, error) {
-	fetcher := func(u string) ([]byte, error) {
-		content, err := http.Get(u)
-		if err != nil {
-			return nil, err
-		}
-		defer content.Body.Close()
+// FetchList downloads and parses a single list. Unlike GenerateListMap,
+// failures are returned to the caller.
+func (f *ListFetcher) FetchList(ctx context.Context, listUrl string, fetchFunc listFetchFunc) (ListMap, error) {
+	return f.fetchAndParse(ctx, listUrl, fetchFunc, parseListFile)
+}
 
-		data, err := ioutil.ReadAll(content.Body)
-		if err != nil {
-			return nil, err
+// FetchNetworkList downloads and parses a single list of networks.
+func (f *ListFetcher) FetchNetworkList(ctx context.Context, listUrl string, fetchFunc listFetchFunc) (ListMap, error) {
+	return f.fetchAndParse(ctx, listUrl, fetchFunc, parseNetworkListFile)
+}
+
+func (f *ListFetcher) fetchAndParse(ctx context.Context, listUrl string, fetchFunc listFetchFunc, parse func([]byte, ListMap)) (ListMap, error) {
+	data, err := f.fetchAndUnpack(ctx, listUrl, fetchFunc)
+	data, err = f.applyVerificationResult(listUrl, data, err)
+	if err != nil {
+		return nil, err
+	}
+
+	listMap := make(ListMap, 0)
+	parse(data, listMap)
+	return listMap, nil
+}
+
+func (f *ListFetcher) GenerateListMapFromHTTPUrls(ctx context.Context, listUrls []string) (ListMap, error) {
+	return f.GenerateListMap(ctx, listUrls, f.fetchHTTP)
+}
+
+func (f *ListFetcher) GenerateListMapFromFileUrls(ctx context.Context, listUrls []string) (ListMap, error) {
+	return f.GenerateListMap(ctx, listUrls, f.fetchFile)
+}
+
+// fetchAndUnpack downloads and verifies a list. Compressed lists get
+// unpacked once they passed the verification.
+func (f *ListFetcher) fetchAndUnpack(ctx context.Context, listUrl string, fetchFunc listFetchFunc) ([]byte, error) {
+	list, err := f.fetch(ctx, listUrl, fetchFunc)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.verify(ctx, listUrl, list.Data); err != nil {
+		return nil, err
+	}
+
+	archiveMember := ""
+	if source := f.Lists[listUrl]; source != nil {
+		archiveMember = source.ArchiveMember
+	}
+	return f.decompress(list, archiveMember)
+}
+
+func (f *ListFetcher) fetch(ctx context.Context, ref string, fetchFunc listFetchFunc) (*fetchedList, error) {
+	if f.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.Timeout)
+		defer cancel()
+	}
+	return fetchFunc(ctx, ref)
+}
+
+// applyVerificationResult remembers successfully verified lists and
+// replaces lists failing the verification with their last verified version.
+// Other errors, e.g. failed downloads or lists exceeding the size limit, are
+// returned as they are.
+func (f *ListFetcher) applyVerificationResult(listUrl string, data []byte, err error) ([]byte, error) {
+	if source := f.Lists[listUrl]; source == nil || source.Verification == nil {
+		return data, err
+	}
+
+	f.verifiedMutex.Lock()
+	defer f.verifiedMutex.Unlock()
+
+	if err == nil {
+		if f.lastVerified == nil {
+			f.lastVerified = make(map[string][]byte)
 		}
+		f.lastVerified[listUrl] = data
 		return data, nil
 	}
-	return GenerateListMap(listUrls, fetcher)
+
+	if !errors.Is(err, errListVerification) {
+		return nil, err
+	}
+	listVerificationFailureCount.WithLabelValues(listUrl).Inc()
+	log.Errorf("Refusing to apply list %q: %s", listUrl, err.Error())
+
+	previous, ok := f.lastVerified[listUrl]
+	if !ok {
+		return nil, err
+	}
+	log.Warningf("Keeping the last verified version of list %q", listUrl)
+	return previous, nil
 }
 
-func GenerateListMapFromFileUrls(listUrls []string) (ListMap, error) {
-	fetcher := func(u string) ([]byte, error) {
-		u = strings.TrimPrefix(u, "file://")
-		stream, err := os.Open(u)
-		if err != nil {
-			return nil, err
+func (f *ListFetcher) fetchHTTP(ctx context.Context, u string) (*fetchedList, error) {
+	client := f.Clients[u]
+	if client == nil {
+		client = &httpListClient{Client: f.Client, Config: &httpClientConfig{}}
+		if client.Client == nil {
+			client.Client = http.DefaultClient
 		}
+	}
+	return f.fetchHTTPWithClient(ctx, client, u)
+}
 
-		defer stream.Close()
+func (f *ListFetcher) fetchHTTPWithClient(ctx context.Context, client *httpListClient, u string) (*fetchedList, error) {
+	req, err := client.newRequest(ctx, u)
+	if err != nil {
+		return nil, err
+	}
 
-		data, err := ioutil.ReadAll(stream)
-		if err != nil {
-			return nil, err
-		}
-		return data, nil
+	content, err := client.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Body.Close()
+
+	if content.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status %q", content.Status)
+	}
+
+	data, err := f.readLimited(content.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &fetchedList{
+		Data:        data,
+		Name:        content.Request.URL.Path,
+		ContentType: content.Header.Get("Content-Type"),
+	}
+	if !content.Uncompressed {
+		list.ContentEncoding = content.Header.Get("Content-Encoding")
+	}
+	return list, nil
+}
+
+func (f *ListFetcher) fetchFile(ctx context.Context, u string) (*fetchedList, error) {
+	u = strings.TrimPrefix(u, "file://")
+	stream, err := os.Open(u)
+	if err != nil {
+		return nil, err
+	}
+
+	defer stream.Close()
+
+	data, err := f.readLimited(stream)
+	if err != nil {
+		return nil, err
+	}
+	return &fetchedList{Data: data, Name: u}, nil
+}
+
+func (f *ListFetcher) readLimited(r io.Reader) ([]byte, error) {
+	if f.MaxSize <= 0 {
+		return ioutil.ReadAll(r)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r, f.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > f.MaxSize {
+		return nil, errListTooLarge
 	}
-	return GenerateListMap(listUrls, fetcher)
+	return data, nil
 }
 
 func parseListFile(data []byte, blockageMap ListMap) {
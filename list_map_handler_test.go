@@ -17,6 +17,7 @@
 package ads
 
 import (
+	"context"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
@@ -24,7 +25,9 @@ import (
 	"net/http/httptest"
 	"os"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestListFetch(t *testing.T) {
@@ -57,3 +60,88 @@ func TestListFetch(t *testing.T) {
 	}
 	assert.False(t, list["testme.com"])
 }
+
+func TestListFetch_Timeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-req.Context().Done():
+		}
+	}))
+	defer slow.Close()
+
+	srv := initTestServer(t)
+	defer srv.Close()
+
+	fetcher := &ListFetcher{Workers: 2, Timeout: 200 * time.Millisecond}
+
+	start := time.Now()
+	list, err := fetcher.GenerateListMapFromHTTPUrls(context.Background(), []string{slow.URL, srv.URL})
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) < 2*time.Second)
+	assert.Equal(t, 1000, len(list))
+}
+
+func TestListFetch_MaxSize(t *testing.T) {
+	srv := initTestServer(t)
+	defer srv.Close()
+
+	fetcher := &ListFetcher{Workers: 1, MaxSize: 1024}
+
+	list, err := fetcher.GenerateListMapFromHTTPUrls(context.Background(), []string{srv.URL})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(list))
+
+	_, err = fetcher.readLimited(strings.NewReader(strings.Repeat("a", 1025)))
+	assert.Equal(t, errListTooLarge, err)
+
+	data, err := fetcher.readLimited(strings.NewReader(strings.Repeat("a", 1024)))
+	assert.NoError(t, err)
+	assert.Equal(t, 1024, len(data))
+}
+
+func TestListFetch_Parallel(t *testing.T) {
+	var running, maxRunning int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprintf(w, "0.0.0.0 host%s.example.com\n", strings.TrimPrefix(req.URL.Path, "/"))
+	}))
+	defer srv.Close()
+
+	urls := make([]string, 0)
+	for i := 0; i < 8; i++ {
+		urls = append(urls, fmt.Sprintf("%s/%d", srv.URL, i))
+	}
+
+	fetcher := &ListFetcher{Workers: 4}
+	list, err := fetcher.GenerateListMapFromHTTPUrls(context.Background(), urls)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, len(list))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&maxRunning))
+}
+
+func TestListFetch_Cancel(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-req.Context().Done():
+		}
+	}))
+	defer slow.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	fetcher := &ListFetcher{Workers: 1}
+	list, err := fetcher.GenerateListMapFromHTTPUrls(ctx, []string{slow.URL})
+	assert.Error(t, err)
+	assert.Nil(t, list)
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"github.com/coredns/caddy"
+	"github.com/coredns/caddy/caddyfile"
+	"github.com/coredns/coredns/plugin"
+)
+
+// listSets holds the tokens of the named list sets, keyed by their name
+type listSets map[string][]caddyfile.Token
+
+type listSetsKey struct{}
+
+// listSetsOf returns the list sets defined by the ads blocks parsed so far.
+// They are stored in the server instance, so every reload starts without
+// any definitions.
+func listSetsOf(c *caddy.Controller) listSets {
+	if sets, ok := c.Get(listSetsKey{}).(listSets); ok {
+		return sets
+	}
+	sets := make(listSets)
+	c.Set(listSetsKey{}, sets)
+	return sets
+}
+
+// define captures the block of a `listset NAME { ... }` definition. The
+// options are only parsed once the set is used.
+func (s listSets) define(c *caddy.Controller) error {
+	args := c.RemainingArgs()
+	if len(args) != 1 {
+		return c.Err("A list set has to be defined as 'listset <NAME> { ... }'")
+	}
+	name := args[0]
+	if _, ok := s[name]; ok {
+		return c.Errf("The list set %q is already defined", name)
+	}
+	if !c.NextArg() || c.Val() != "{" {
+		return c.Errf("No block found for the list set %q", name)
+	}
+
+	// The block is replayed as if it was the block of an ads directive
+	tokens := []caddyfile.Token{
+		{File: c.File(), Line: c.Line(), Text: "listset"},
+		{File: c.File(), Line: c.Line(), Text: "{"},
+	}
+	for nesting := 1; c.Next(); {
+		switch c.Val() {
+		case "{":
+			nesting++
+		case "}":
+			nesting--
+		}
+		tokens = append(tokens, caddyfile.Token{File: c.File(), Line: c.Line(), Text: c.Val()})
+		if nesting == 0 {
+			s[name] = tokens
+			return nil
+		}
+	}
+	return c.EOFErr()
+}
+
+// apply parses the options of the list set into the given configuration.
+func (s listSets) apply(c *caddy.Controller, config *adsPluginConfig, name string, using []string) error {
+	tokens, ok := s[name]
+	if !ok {
+		return plugin.Error("ads", c.Errf("The list set %q is not defined, list sets have to be defined before they are used", name))
+	}
+	for _, v := range using {
+		if v == name {
+			return plugin.Error("ads", c.Errf("The list set %q uses itself", name))
+		}
+	}
+
+	set := &caddy.Controller{Dispenser: caddyfile.NewDispenserTokens(c.File(), tokens)}
+	set.Next()
+	return parseOptions(set, config, s, append(using, name))
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListSet_Use(t *testing.T) {
+	c := caddy.NewTestController("dns", `ads {
+  listset family {
+    blacklist https://lists.local/adult.txt {
+      category adult
+    }
+    block-regex (^|\.)casino\. {
+      action nxdomain
+    }
+    permit allowed.example.com
+  }
+  listset kids {
+    use family
+    block games.example.com
+  }
+  use family
+}
+ads {
+  use kids
+  block other.example.com
+}`)
+	c.Next()
+	cfg, err := parsePluginConfiguration(c)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"https://lists.local/adult.txt"}, cfg.BlacklistURLs)
+	assert.Equal(t, []string{"adult"}, cfg.ListSources["https://lists.local/adult.txt"].Categories)
+	assert.Equal(t, []string{"allowed.example.com"}, cfg.WhitelistRules)
+	assert.Equal(t, responseModeNXDomain, cfg.RegexBlacklistRuleActions[`(^|\.)casino\.`].Mode)
+	assert.Empty(t, cfg.BlacklistRules)
+
+	// Sets defined in a previous block of the same instance are available
+	assert.True(t, c.Next())
+	cfg, err = parsePluginConfiguration(c)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"https://lists.local/adult.txt"}, cfg.BlacklistURLs)
+	assert.Equal(t, []string{"games.example.com", "other.example.com"}, cfg.BlacklistRules)
+}
+
+func TestListSet_Invalid(t *testing.T) {
+	for _, v := range []string{
+		"ads {\n use family\n}",
+		"ads {\n use\n}",
+		"ads {\n listset {\n block a.example.com\n }\n}",
+		"ads {\n listset family\n}",
+		"ads {\n listset family {\n block a.example.com",
+		"ads {\n listset family {\n }\n listset family {\n }\n}",
+		"ads {\n listset family {\n listset kids {\n }\n }\n use family\n}",
+		"ads {\n listset family {\n blacklist ftp://lists.local/list.txt\n }\n use family\n}",
+		"ads {\n listset a {\n use b\n }\n listset b {\n use a\n }\n use a\n}",
+	} {
+		c := caddy.NewTestController("dns", v)
+		c.Next()
+		_, err := parsePluginConfiguration(c)
+		assert.Error(t, err, v)
+	}
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+// listSnapshot holds the lists loaded by an updater. A published snapshot
+// is never modified, every update publishes a new one, so queries can read
+// it without locking.
+type listSnapshot struct {
+	// http holds the merged HTTP lists, including the fallback list
+	http UpdateableRuleset
+	// files holds the merged lists loaded from files
+	files UpdateableRuleset
+}
+
+var emptyListSnapshot = &listSnapshot{}
+
+// lists returns the lists currently published by the updater.
+func (u *ListUpdater) lists() *listSnapshot {
+	if u == nil {
+		return emptyListSnapshot
+	}
+	if s, ok := u.snapshot.Load().(*listSnapshot); ok {
+		return s
+	}
+	return emptyListSnapshot
+}
+
+// publish replaces the published lists by a modified copy of the current
+// ones and notifies everyone waiting for an update.
+func (u *ListUpdater) publish(modify func(s *listSnapshot)) {
+	u.publishMutex.Lock()
+	defer u.publishMutex.Unlock()
+
+	s := *u.lists()
+	modify(&s)
+	u.snapshot.Store(&s)
+	if u.published != nil {
+		close(u.published)
+		u.published = nil
+	}
+}
+
+// updated returns a channel which is closed once the next lists have been
+// published.
+func (u *ListUpdater) updated() <-chan struct{} {
+	u.publishMutex.Lock()
+	defer u.publishMutex.Unlock()
+	if u.published == nil {
+		u.published = make(chan struct{})
+	}
+	return u.published
+}
+
+// ruleset builds the lookup structures of the merged lists.
+func (l listsByKind) ruleset() UpdateableRuleset {
+	return UpdateableRuleset{
+		Blacklist:            l[listKindBlacklist],
+		Whitelist:            l[listKindWhitelist],
+		BlacklistNetworks:    networkSetFromList(l[listKindNetworks]),
+		CnameCloakingTargets: l[listKindCnameCloaking],
+	}
+}
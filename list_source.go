@@ -0,0 +1,200 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/coredns/caddy"
+)
+
+// listSourceConfig holds the options of a single `blacklist` or `whitelist`
+// entry, configured in the optional block following the list URL.
+type listSourceConfig struct {
+	URL           string
+	Kind          listKind
+	Order         int
+	HTTP          *httpClientConfig
+	Verification  *listVerification
+	ArchiveMember string
+	Action        *blockAction
+	Categories    []string
+	ExpectedSize  int
+
+	UpdateInterval time.Duration
+	RetryCount     int
+	RetryInterval  time.Duration
+}
+
+func parseListSourceOption(c *caddy.Controller, source *listSourceConfig, isHTTP bool) error {
+	httpConfig := source.HTTP
+	if httpConfig == nil {
+		httpConfig = &httpClientConfig{Headers: make(http.Header)}
+	}
+	ok, err := parseHTTPClientOption(c, httpConfig)
+	if err != nil {
+		return err
+	} else if ok {
+		if !isHTTP {
+			return c.Errf("The option %q is only supported for HTTP lists", c.Val())
+		}
+		source.HTTP = httpConfig
+		return nil
+	}
+
+	switch c.Val() {
+	case "sha256":
+		if !c.NextArg() {
+			return c.Err("No SHA-256 checksum defined")
+		}
+		sum, err := parseSHA256(c.Val())
+		if err != nil {
+			return c.Err(err.Error())
+		}
+		source.verification().SHA256 = sum
+	case "signature":
+		if !c.NextArg() {
+			return c.Err("No signature URL defined")
+		}
+		source.verification().SignatureURL = c.Val()
+	case "public-key":
+		if !c.NextArg() {
+			return c.Err("No public key defined")
+		}
+		key, err := parsePublicKey(c.Val())
+		if err != nil {
+			return c.Err(err.Error())
+		}
+		source.verification().PublicKey = key
+	case "archive-member":
+		if !c.NextArg() {
+			return c.Err("No archive member defined")
+		}
+		if _, err := path.Match(c.Val(), ""); err != nil {
+			return c.Errf("Invalid archive member pattern %q", c.Val())
+		}
+		source.ArchiveMember = c.Val()
+	case "update-interval", "retry-interval":
+		option := c.Val()
+		if !isHTTP {
+			return c.Errf("The option %q is only supported for HTTP lists", option)
+		}
+		if !c.NextArg() {
+			return c.Errf("No duration for %q defined", option)
+		}
+		d, err := time.ParseDuration(c.Val())
+		if err != nil || d <= 0 {
+			return c.Errf("Invalid duration %q for %q", c.Val(), option)
+		}
+		if option == "update-interval" {
+			source.UpdateInterval = d
+		} else {
+			source.RetryInterval = d
+		}
+	case "retry-count":
+		if !isHTTP {
+			return c.Errf("The option %q is only supported for HTTP lists", c.Val())
+		}
+		if !c.NextArg() {
+			return c.Err("No retry count defined")
+		}
+		n, err := strconv.Atoi(c.Val())
+		if err != nil || n < 1 {
+			return c.Err("The retry count has to be a positive number")
+		}
+		source.RetryCount = n
+	case "action":
+		if source.Kind != listKindBlacklist {
+			return c.Err("Actions are only supported for blacklists")
+		}
+		action, err := parseBlockAction(c)
+		if err != nil {
+			return err
+		}
+		source.Action = action
+	case "category":
+		if source.Kind != listKindBlacklist {
+			return c.Err("Categories are only supported for blacklists")
+		}
+		categories, err := parseCategories(c)
+		if err != nil {
+			return err
+		}
+		source.Categories = append(source.Categories, categories...)
+	default:
+		return c.Errf("Unknown list option %q", c.Val())
+	}
+	return nil
+}
+
+func (s *listSourceConfig) verification() *listVerification {
+	if s.Verification == nil {
+		s.Verification = &listVerification{}
+	}
+	return s.Verification
+}
+
+// validate checks the combination of options once the block of a source
+// has been parsed completely.
+func (s *listSourceConfig) validate(c *caddy.Controller) error {
+	if v := s.Verification; v != nil {
+		if v.SignatureURL != "" && v.PublicKey == nil {
+			return c.Errf("The list %q defines a signature but no public key", s.URL)
+		}
+		if v.PublicKey != nil && v.SignatureURL == "" {
+			// Follow the naming convention of minisign by default
+			v.SignatureURL = s.URL + ".minisig"
+		}
+	}
+	return nil
+}
+
+// newListFetcher builds the fetcher used by the list updater, including the
+// HTTP clients of all sources with custom HTTP options.
+func newListFetcher(cfg *adsPluginConfig) (*ListFetcher, error) {
+	fetcher := &ListFetcher{
+		Workers: cfg.ListFetchWorkers,
+		Timeout: cfg.ListFetchTimeout,
+		MaxSize: cfg.ListMaxSize,
+		Client:  http.DefaultClient,
+		Clients: make(map[string]*httpListClient),
+		Lists:   cfg.ListSources,
+	}
+
+	urls := append(append([]string{}, cfg.BlacklistURLs...), cfg.WhitelistURLs...)
+	urls = append(urls, cfg.NetworkBlacklistURLs...)
+	urls = append(urls, cfg.CnameCloakingURLs...)
+	for _, u := range urls {
+		var sourceConfig *httpClientConfig
+		if source := cfg.ListSources[u]; source != nil {
+			sourceConfig = source.HTTP
+		}
+		if cfg.HTTPClient == nil && sourceConfig == nil {
+			continue
+		}
+		client, err := newHTTPListClient(cfg.HTTPClient.merge(sourceConfig))
+		if err != nil {
+			return nil, err
+		}
+		fetcher.Clients[u] = client
+	}
+
+	return fetcher, nil
+}
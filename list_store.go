@@ -23,12 +23,36 @@ import (
 	"time"
 )
 
+// StoredListConfiguration is the content of the list store. Since every
+// list gets updated independently, the lists are stored per source in
+// Sources. Blacklist and Whitelist are only set by previous versions.
 type StoredListConfiguration struct {
+	UpdateTimestamp int                `json:"update_timestamp"`
+	BlacklistURLs   []string           `json:"blacklist_urls"`
+	WhitelistURLs   []string           `json:"whitelist_urls"`
+	Blacklist       ListMap            `json:"blacklist,omitempty"`
+	Whitelist       ListMap            `json:"whitelist,omitempty"`
+	Sources         []StoredListSource `json:"sources,omitempty"`
+}
+
+type StoredListSource struct {
+	URL             string   `json:"url"`
+	Whitelist       bool     `json:"whitelist"`
+	Kind            listKind `json:"kind,omitempty"`
 	UpdateTimestamp int      `json:"update_timestamp"`
-	BlacklistURLs   []string `json:"blacklist_urls"`
-	WhitelistURLs   []string `json:"whitelist_urls"`
-	Blacklist       ListMap  `json:"blacklist"`
-	Whitelist       ListMap  `json:"whitelist"`
+	List            ListMap  `json:"list"`
+}
+
+// kind returns the kind of the list. Stores of previous versions only
+// contain blacklists and whitelists.
+func (s StoredListSource) kind() listKind {
+	if s.Kind != "" {
+		return s.Kind
+	}
+	if s.Whitelist {
+		return listKindWhitelist
+	}
+	return listKindBlacklist
 }
 
 func ReadListConfiguration(path string) (*StoredListConfiguration, error) {
@@ -66,7 +90,7 @@ func (s *StoredListConfiguration) Persist(path string) error {
 		return err
 	}
 
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0666)
 	if err != nil {
 		return err
 	}
@@ -17,7 +17,11 @@
 package ads
 
 import (
-	"fmt"
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,170 +30,614 @@ type ListUpdater struct {
 	UpdateInterval time.Duration
 	RetryCount     int
 	RetryDelay     time.Duration
+	// Fallback enables the embedded fallback list while no blacklist has
+	// been loaded
+	Fallback bool
 
-	Plugin *DNSAdBlock
+	Plugin  *DNSAdBlock
+	Fetcher *ListFetcher
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	routines sync.WaitGroup
 
 	persistLists          bool
 	persistencePath       string
 	lastPersistenceUpdate time.Time
 
-	httpUpdateTicker *time.Ticker
+	sourceMutex    sync.Mutex
+	sources        []*listSourceState
+	fallbackActive bool
+
+	// loaded is set once all lists have been loaded for the first time,
+	// regardless of the success of the downloads
+	loaded int32
+
+	// snapshot holds the published *listSnapshot, published is closed on
+	// the next update
+	snapshot     atomic.Value
+	publishMutex sync.Mutex
+	published    chan struct{}
+
+	fileMutex        sync.Mutex
+	fileSources      map[string]*fileSourceState
 	fileUpdateTicker *time.Ticker
-	lastUpdate       *time.Time
+}
+
+// listKind determines how the entries of a list are used.
+type listKind string
+
+const (
+	listKindBlacklist     listKind = "blacklist"
+	listKindWhitelist     listKind = "whitelist"
+	listKindNetworks      listKind = "networks"
+	listKindCnameCloaking listKind = "cname-cloak"
+)
+
+// listSourceState tracks a single HTTP list, which gets refreshed
+// independently of all other lists.
+type listSourceState struct {
+	URL        string
+	Kind       listKind
+	Interval   time.Duration
+	RetryCount int
+	RetryDelay time.Duration
+
+	list       ListMap
+	lastUpdate time.Time
+	shared     *sharedList
 }
 
 func (u *ListUpdater) Start() {
 	log.Info("Initializing CoreDNS 'ads' list update routines...")
 
+	if u.Fetcher == nil {
+		u.Fetcher = defaultListFetcher
+	}
+	u.ctx, u.cancel = context.WithCancel(context.Background())
+	u.initSources()
+	if u.acquireSharedLists() > 0 || u.Fallback {
+		// Block using the lists loaded by other configurations or the
+		// fallback list until the lists are loaded
+		u.applyLists()
+	}
+
+	u.routines.Add(1)
 	go func() {
+		defer u.routines.Done()
 		//Sleep 250 MS to ensure coredns is up and running
-		time.Sleep(250 * time.Millisecond)
+		if !u.sleep(250 * time.Millisecond) {
+			return
+		}
 
-		if !u.persistLists || !exists(u.persistencePath) {
-			wl, bl, err := u.fetchHTTPLists()
-			if err != nil {
-				log.Error(err)
-				return
+		if u.persistLists && exists(u.persistencePath) {
+			u.loadPersistedLists()
+		}
+		u.fetchMissingLists()
+
+		// Watch the files before loading them, so no change gets lost
+		changes := u.watchFileSources()
+		u.handleFileUpdate()
+		if u.ctx.Err() != nil {
+			return
+		}
+		atomic.StoreInt32(&u.loaded, 1)
+		log.Info("Initial loading of all lists has been completed")
+
+		u.routines.Add(1 + len(u.sources))
+		go func() {
+			defer u.routines.Done()
+			u.runFileUpdater(changes)
+		}()
+		for _, s := range u.sources {
+			go func(s *listSourceState) {
+				defer u.routines.Done()
+				u.runSourceUpdater(s)
+			}(s)
+		}
+	}()
+}
+
+// isLoaded checks if the initial loading of all lists has been completed.
+func (u *ListUpdater) isLoaded() bool {
+	return atomic.LoadInt32(&u.loaded) == 1
+}
+
+// Stop terminates the update routines and aborts all running list downloads.
+// It returns once all routines have exited, so the plugin is no longer
+// modified afterwards.
+func (u *ListUpdater) Stop() {
+	if u.cancel != nil {
+		u.cancel()
+	}
+	u.routines.Wait()
+	u.releaseSharedLists()
+
+	u.sourceMutex.Lock()
+	if u.fallbackActive {
+		u.fallbackActive = false
+		fallbackListActive.Dec()
+	}
+	u.sourceMutex.Unlock()
+}
+
+// sleep waits for the given duration. It returns false if the updater has
+// been stopped in the meantime.
+func (u *ListUpdater) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-u.ctx.Done():
+		return false
+	}
+}
+
+func (u *ListUpdater) initSources() {
+	u.sources = make([]*listSourceState, 0)
+	add := func(urls []string, kind listKind) {
+		for _, listUrl := range urls {
+			state := &listSourceState{
+				URL:        listUrl,
+				Kind:       kind,
+				Interval:   u.UpdateInterval,
+				RetryCount: u.RetryCount,
+				RetryDelay: u.RetryDelay,
 			}
-			u.Plugin.blacklist = bl
-			u.Plugin.whitelist = wl
-			u.persistLoadedHttpLists()
-		} else {
-			storedListSet, err := ReadListConfiguration(u.persistencePath)
+			if source := u.Plugin.config.ListSources[listUrl]; source != nil {
+				if source.UpdateInterval > 0 {
+					state.Interval = source.UpdateInterval
+				}
+				if source.RetryCount > 0 {
+					state.RetryCount = source.RetryCount
+				}
+				if source.RetryInterval > 0 {
+					state.RetryDelay = source.RetryInterval
+				}
+			}
+			if state.RetryCount < 1 {
+				state.RetryCount = 1
+			}
+			u.sources = append(u.sources, state)
+		}
+	}
+	add(u.Plugin.config.BlacklistURLs, listKindBlacklist)
+	add(u.Plugin.config.WhitelistURLs, listKindWhitelist)
+	add(u.Plugin.config.NetworkBlacklistURLs, listKindNetworks)
+	add(u.Plugin.config.CnameCloakingURLs, listKindCnameCloaking)
+}
+
+// loadPersistedLists restores the lists from the list store. Lists which
+// are not part of the store get fetched afterwards, outdated lists get
+// updated by their update routine right away.
+func (u *ListUpdater) loadPersistedLists() {
+	if !u.Enabled {
+		// Without automatic updates, the lists are reloaded on every start
+		return
+	}
+
+	storedListSet, err := ReadListConfiguration(u.persistencePath)
+	if err != nil {
+		log.Errorf("Loading persisted lists from %q failed: %s", u.persistencePath, err.Error())
+		return
+	}
+	if len(storedListSet.Sources) == 0 {
+		log.Info("The list store has been created by a previous version, reloading all lists")
+		return
+	}
+
+	stored := make(map[string]StoredListSource)
+	for _, v := range storedListSet.Sources {
+		stored[sourceKey(v.URL, v.kind())] = v
+	}
+
+	u.sourceMutex.Lock()
+	for _, s := range u.sources {
+		if v, ok := stored[sourceKey(s.URL, s.Kind)]; ok && s.list == nil {
+			// Lists loaded by other configurations are newer
+			s.list = v.List
+			s.lastUpdate = time.Unix(int64(v.UpdateTimestamp), 0)
+			if s.shared != nil {
+				s.shared.set(s.list, s.lastUpdate)
+			}
+		}
+	}
+	u.sourceMutex.Unlock()
+
+	u.lastPersistenceUpdate = time.Unix(int64(storedListSet.UpdateTimestamp), 0)
+	u.applyLists()
+}
+
+// fetchMissingLists performs the initial download of all lists that could not
+// be restored from the list store.
+func (u *ListUpdater) fetchMissingLists() {
+	workers := u.Fetcher.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	semaphore := make(chan struct{}, workers)
+
+	var wg sync.WaitGroup
+	for _, s := range u.sources {
+		if s.list != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(s *listSourceState) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			list, lastUpdate, err := u.loadSource(s, time.Time{})
 			if err != nil {
-				panic(fmt.Sprintf("Loading persisted blocklist from %q failed", u.persistencePath))
+				log.Warningf("Loading list from url %q failed with error: %s", s.URL, err.Error())
+				return
 			}
-			if storedListSet.NeedsUpdate(u.UpdateInterval) && u.Enabled ||
-				!validateURLListEquality(u.Plugin.config.BlacklistURLs, storedListSet.BlacklistURLs) && u.Enabled ||
-				!validateURLListEquality(u.Plugin.config.WhitelistURLs, storedListSet.WhitelistURLs) && u.Enabled ||
-				!u.Enabled {
-				wl, bl, err := u.fetchHTTPLists()
-				if err != nil {
-					log.Error(err)
-					return
-				}
-				u.Plugin.blacklist = bl
-				u.Plugin.whitelist = wl
-				u.persistLoadedHttpLists()
-			} else {
-				u.Plugin.whitelist = storedListSet.Whitelist
-				u.Plugin.blacklist = storedListSet.Blacklist
+			u.setSourceList(s, list, lastUpdate)
+		}(s)
+	}
+	wg.Wait()
 
-				log.Infof("Loaded Whitelist (HTTP) Length: %d", len(storedListSet.Whitelist))
-				log.Infof("Loaded Blacklist (HTTP) Length: %d", len(storedListSet.Blacklist))
+	if u.ctx.Err() != nil {
+		return
+	}
+	u.applyLists()
+	u.persistLoadedHttpLists()
+}
 
-				u.lastPersistenceUpdate = time.Unix(int64(storedListSet.UpdateTimestamp), 0)
+func (u *ListUpdater) runSourceUpdater(s *listSourceState) {
+	next := s.lastUpdate.Add(s.Interval)
+	if s.list == nil {
+		// The initial download failed, start retrying right away
+		next = time.Now()
+	} else if !u.Enabled {
+		return
+	}
+
+	for {
+		if !u.sleep(time.Until(next)) {
+			return
+		}
+
+		if u.updateSource(s) {
+			if !u.Enabled {
+				return
+			}
+			next = time.Now().Add(s.Interval)
+			log.Infof("Scheduled next update of list %q in %s at %s", s.URL, s.Interval.String(), next.String())
+		} else {
+			if u.ctx.Err() != nil {
+				return
 			}
+			next = time.Now().Add(s.retryDelay(s.RetryCount))
+			log.Errorf("Updating list %q has failed, next attempt at %s", s.URL, next.String())
 		}
+	}
+}
 
-		go u.runFileUpdater()
+// updateSource downloads a list, retrying with exponential backoff if the
+// download fails. The list is only replaced if the download succeeds.
+func (u *ListUpdater) updateSource(s *listSourceState) bool {
+	log.Infof("Updating list %q...", s.URL)
+	u.sourceMutex.Lock()
+	previousUpdate := s.lastUpdate
+	u.sourceMutex.Unlock()
 
-		if u.Enabled {
-			go u.runHttpUpdater()
+	for attempt := 0; attempt < s.RetryCount; attempt++ {
+		list, lastUpdate, err := u.loadSource(s, previousUpdate)
+		if err == nil {
+			u.setSourceList(s, list, lastUpdate)
+			u.applyLists()
+			u.persistLoadedHttpLists()
+			return true
 		}
-	}()
+		if u.ctx.Err() != nil {
+			return false
+		}
+
+		log.Errorf("Attempt %d/%d to update list %q failed. Error %q", attempt+1, s.RetryCount, s.URL, err.Error())
+		if attempt+1 < s.RetryCount && !u.sleep(s.retryDelay(attempt)) {
+			return false
+		}
+	}
+	return false
 }
 
-func (u *ListUpdater) runFileUpdater() {
-	u.fileUpdateTicker = time.NewTicker(u.Plugin.config.FileListRenewalInterval)
-	u.handleFileUpdate()
+func (u *ListUpdater) fetchSource(s *listSourceState) (ListMap, error) {
+	var list ListMap
+	var err error
+	if s.Kind == listKindNetworks {
+		list, err = u.Fetcher.FetchNetworkList(u.ctx, s.URL, u.Fetcher.fetchHTTP)
+	} else {
+		list, err = u.Fetcher.FetchList(u.ctx, s.URL, u.Fetcher.fetchHTTP)
+	}
+	if err == nil {
+		u.checkListSize(s, list)
+	}
+	return list, err
+}
 
-	for range u.fileUpdateTicker.C {
-		u.handleFileUpdate()
+// checkListSize warns if a list is far smaller than expected by the list
+// catalog, which usually means the list is no longer maintained.
+func (u *ListUpdater) checkListSize(s *listSourceState, list ListMap) {
+	source := u.Plugin.config.ListSources[s.URL]
+	if source == nil || source.ExpectedSize == 0 {
+		return
+	}
+	if len(list) < source.ExpectedSize/10 {
+		log.Warningf("The list %q contains only %d entries, about %d have been expected. The list might be dead",
+			s.URL, len(list), source.ExpectedSize)
 	}
 }
 
-func (u *ListUpdater) handleFileUpdate() {
-	log.Info("Updating lists from Local files...")
+// retryDelay returns the delay before the given retry attempt. The delay
+// doubles with every attempt, is capped at the update interval and gets
+// randomized by +/- 50% to spread the load on the list hosts.
+func (s *listSourceState) retryDelay(attempt int) time.Duration {
+	delay := s.RetryDelay
+	for i := 0; i < attempt && delay < s.Interval; i++ {
+		delay *= 2
+	}
+	if delay > s.Interval {
+		delay = s.Interval
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay)))
+}
 
-	wl, bl, err := u.fetchFileLists()
-	if err != nil {
-		log.Errorf("Loading File lists has failed. Error message: %q", err.Error())
+func (u *ListUpdater) setSourceList(s *listSourceState, list ListMap, lastUpdate time.Time) {
+	u.sourceMutex.Lock()
+	defer u.sourceMutex.Unlock()
+	s.list = list
+	s.lastUpdate = lastUpdate
+}
+
+// applyLists merges the lists of all sources and publishes them.
+func (u *ListUpdater) applyLists() {
+	u.sourceMutex.Lock()
+	defer u.sourceMutex.Unlock()
+
+	lists := newListsByKind()
+	for _, s := range u.sources {
+		lists.add(s.Kind, s.list)
+	}
+	fallback := 0
+	if u.needsFallback() {
+		list := fallbackList()
+		lists.add(listKindBlacklist, list)
+		fallback = len(list)
+	}
+	u.setFallbackActive(fallback)
+
+	u.publish(func(s *listSnapshot) {
+		s.http = lists.ruleset()
+	})
+	lists.log("HTTP Update")
+}
+
+func (u *ListUpdater) persistLoadedHttpLists() {
+	if !u.persistLists {
 		return
 	}
-	u.Plugin.FileRuleSet.Whitelist = wl
-	u.Plugin.FileRuleSet.Blacklist = bl
+
+	u.sourceMutex.Lock()
+	persistedList := StoredListConfiguration{
+		UpdateTimestamp: int(time.Now().Unix()),
+		BlacklistURLs:   u.Plugin.config.BlacklistURLs,
+		WhitelistURLs:   u.Plugin.config.WhitelistURLs,
+		Sources:         make([]StoredListSource, 0),
+	}
+	for _, s := range u.sources {
+		if s.list == nil {
+			continue
+		}
+		persistedList.Sources = append(persistedList.Sources, StoredListSource{
+			URL:             s.URL,
+			Whitelist:       s.Kind == listKindWhitelist,
+			Kind:            s.Kind,
+			UpdateTimestamp: int(s.lastUpdate.Unix()),
+			List:            s.list,
+		})
+	}
+	u.sourceMutex.Unlock()
+
+	if err := persistedList.Persist(u.persistencePath); err != nil {
+		log.Errorf("Persisting HTTP Lists failed: %s", err.Error())
+		return
+	}
+	u.lastPersistenceUpdate = time.Now()
+}
+
+func sourceKey(listUrl string, kind listKind) string {
+	return string(kind) + ":" + listUrl
 }
 
-func (u *ListUpdater) runHttpUpdater() {
-	log.Info("Updating lists from HTTP URLs...")
-	if u.persistLists {
-		sleepDuration := u.lastPersistenceUpdate.Add(u.UpdateInterval).Sub(time.Now())
-		log.Infof("Scheduled next update in %s", sleepDuration.String())
-		time.Sleep(sleepDuration)
+// listsByKind holds the merged entries of all lists of each kind.
+type listsByKind map[listKind]ListMap
 
-		u.handleHTTPListUpdate()
+func newListsByKind() listsByKind {
+	lists := make(listsByKind)
+	for _, kind := range []listKind{listKindBlacklist, listKindWhitelist, listKindNetworks, listKindCnameCloaking} {
+		lists[kind] = make(ListMap, 0)
 	}
+	return lists
+}
 
-	u.httpUpdateTicker = time.NewTicker(u.UpdateInterval)
+func (l listsByKind) add(kind listKind, list ListMap) {
+	for k := range list {
+		l[kind][k] = true
+	}
+}
 
-	for range u.httpUpdateTicker.C {
-		u.handleHTTPListUpdate()
-		log.Infof("Scheduled next update of HTTP lists in %s at %s", u.UpdateInterval.String(), time.Now().Add(u.UpdateInterval).String())
+func (l listsByKind) log(prefix string) {
+	log.Infof("[%s] Loaded %d entries into Blacklist and %d entries into whitelist", prefix, len(l[listKindBlacklist]), len(l[listKindWhitelist]))
+	if n := len(l[listKindNetworks]); n > 0 {
+		log.Infof("[%s] Loaded %d networks into the network blacklist", prefix, n)
+	}
+	if n := len(l[listKindCnameCloaking]); n > 0 {
+		log.Infof("[%s] Loaded %d CNAME cloaking targets", prefix, n)
 	}
 }
 
-func (u *ListUpdater) fetchHTTPLists() (ListMap, ListMap, error) {
-	blacklist, err := GenerateListMapFromHTTPUrls(u.Plugin.config.BlacklistURLs)
-	if err != nil {
-		return nil, nil, err
+// watchFileSources returns the notifications about changed list files. It
+// returns nil if no list files are configured or watching is not supported.
+func (u *ListUpdater) watchFileSources() <-chan struct{} {
+	patterns := u.filePatterns()
+	if len(patterns) == 0 {
+		return nil
 	}
-	whitelist, err := GenerateListMapFromHTTPUrls(u.Plugin.config.WhitelistURLs)
+
+	changes, err := watchFiles(u.ctx, patterns)
 	if err != nil {
-		return nil, nil, err
+		log.Warningf("Watching list files is not supported, falling back to polling every %s: %s",
+			u.Plugin.config.FileListRenewalInterval.String(), err.Error())
 	}
-	log.Infof("[HTTP Update] Loaded %d entries into Blacklist and %d entries into whitelist", len(blacklist), len(whitelist))
-	return whitelist, blacklist, nil
+	return changes
 }
 
-func (u *ListUpdater) fetchFileLists() (ListMap, ListMap, error) {
-	blacklist, err := GenerateListMapFromFileUrls(u.Plugin.config.BlacklistFiles)
-	if err != nil {
-		return nil, nil, err
+func (u *ListUpdater) filePatterns() []string {
+	patterns := append(append([]string{}, u.Plugin.config.BlacklistFiles...), u.Plugin.config.WhitelistFiles...)
+	patterns = append(patterns, u.Plugin.config.NetworkBlacklistFiles...)
+	return append(patterns, u.Plugin.config.CnameCloakingFiles...)
+}
+
+// runFileUpdater reloads local lists as soon as they change. Changes are
+// detected using filesystem notifications, additionally all files get
+// checked periodically in case notifications are not available.
+func (u *ListUpdater) runFileUpdater(changes <-chan struct{}) {
+	if len(u.filePatterns()) == 0 {
+		return
 	}
-	whitelist, err := GenerateListMapFromFileUrls(u.Plugin.config.WhitelistFiles)
-	if err != nil {
-		return nil, nil, err
+
+	u.fileUpdateTicker = time.NewTicker(u.Plugin.config.FileListRenewalInterval)
+	defer u.fileUpdateTicker.Stop()
+
+	for {
+		select {
+		case <-changes:
+			u.handleFileUpdate()
+		case <-u.fileUpdateTicker.C:
+			u.handleFileUpdate()
+		case <-u.ctx.Done():
+			return
+		}
 	}
-	log.Infof("[File Update] Loaded %d entries into Blacklist and %d entries into whitelist", len(blacklist), len(whitelist))
-	return whitelist, blacklist, nil
 }
 
-func (u *ListUpdater) handleHTTPListUpdate() {
-	log.Infof("Updating and Persisting HTTP lists...")
-	failCount := 0
-	for failCount < u.RetryCount {
+// expandFileSources resolves directories and glob patterns to the files they
+// currently contain. It returns true if files have been removed since the
+// last expansion.
+func (u *ListUpdater) expandFileSources() bool {
+	current := make(map[string]*fileSourceState)
+	add := func(patterns []string, kind listKind) {
+		for _, pattern := range patterns {
+			paths, err := expandFileSource(pattern)
+			if err != nil {
+				log.Errorf("Listing files of %q has failed. Error message: %q", pattern, err.Error())
+			}
+			for _, path := range paths {
+				key := sourceKey(path, kind)
+				if s, ok := u.fileSources[key]; ok {
+					current[key] = s
+				} else {
+					current[key] = &fileSourceState{Path: path, Kind: kind, Source: pattern}
+				}
+			}
+		}
+	}
+	add(u.Plugin.config.BlacklistFiles, listKindBlacklist)
+	add(u.Plugin.config.WhitelistFiles, listKindWhitelist)
+	add(u.Plugin.config.NetworkBlacklistFiles, listKindNetworks)
+	add(u.Plugin.config.CnameCloakingFiles, listKindCnameCloaking)
+
+	removed := false
+	for key, s := range u.fileSources {
+		if _, ok := current[key]; !ok && s.list != nil {
+			log.Infof("List file %q has been removed", s.Path)
+			removed = true
+		}
+	}
+	u.fileSources = current
+	return removed
+}
 
-		whitelist, blacklist, err := u.fetchHTTPLists()
+func (u *ListUpdater) handleFileUpdate() {
+	u.fileMutex.Lock()
+	defer u.fileMutex.Unlock()
+
+	// The initial update always publishes the file lists, replacing the ones
+	// taken over from a previous policy
+	initial := u.fileSources == nil
+	changed := u.expandFileSources() || initial
+	for _, s := range u.fileSources {
+		sourceChanged, err := s.refresh(u.ctx, u.Fetcher)
 		if err != nil {
-			log.Errorf("Attempt %d/%d failed. Error %q", failCount+1, u.RetryCount, err.Error())
-			failCount++
-			time.Sleep(u.RetryDelay)
-			continue
+			log.Errorf("Loading list file %q has failed. Error message: %q", s.Path, err.Error())
 		}
-		u.Plugin.blacklist = blacklist
-		u.Plugin.whitelist = whitelist
+		changed = changed || sourceChanged
+	}
+	if !changed {
+		return
+	}
 
-		lastUpdate := time.Now()
-		u.lastUpdate = &lastUpdate
+	lists := newListsByKind()
+	for _, s := range u.fileSources {
+		lists.add(s.Kind, s.list)
+	}
 
-		if u.persistLists {
-			persistedList := StoredListConfiguration{
-				UpdateTimestamp: int(time.Now().Unix()),
-				BlacklistURLs:   u.Plugin.config.BlacklistURLs,
-				WhitelistURLs:   u.Plugin.config.WhitelistURLs,
-				Blacklist:       blacklist,
-				Whitelist:       whitelist,
-			}
+	u.publish(func(s *listSnapshot) {
+		s.files = lists.ruleset()
+	})
+	lists.log("File Update")
+}
 
-			err := persistedList.Persist(u.persistencePath)
-			if err == nil {
-				u.lastPersistenceUpdate = time.Now()
-			} else {
-				log.Error("Persisting HTTP Lists failed.")
-			}
+// Provenance returns the URLs and paths of all lists containing the given name.
+func (u *ListUpdater) Provenance(qname string, kind listKind) []string {
+	sources := make([]string, 0)
+
+	u.sourceMutex.Lock()
+	for _, s := range u.sources {
+		if s.Kind == kind && s.list[qname] {
+			sources = append(sources, s.URL)
+		}
+	}
+	u.sourceMutex.Unlock()
+
+	u.fileMutex.Lock()
+	for _, s := range u.fileSources {
+		if s.Kind == kind && s.list[qname] {
+			sources = append(sources, "file://"+s.Path)
 		}
-		log.Info("Lists with HTTP URLs have been updated")
-		break
 	}
+	u.fileMutex.Unlock()
+
+	sort.Strings(sources)
+	return sources
+}
+
+// matchingSources returns the configured URLs and file patterns of all lists
+// of the given kind containing qname.
+func (u *ListUpdater) matchingSources(qname string, kind listKind) []string {
+	sources := make([]string, 0)
+
+	u.sourceMutex.Lock()
+	for _, s := range u.sources {
+		if s.Kind == kind && s.list[qname] {
+			sources = append(sources, s.URL)
+		}
+	}
+	u.sourceMutex.Unlock()
+
+	u.fileMutex.Lock()
+	for _, s := range u.fileSources {
+		if s.Kind == kind && s.list[qname] {
+			sources = append(sources, s.Source)
+		}
+	}
+	u.fileMutex.Unlock()
+
+	return sources
 }
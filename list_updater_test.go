@@ -18,11 +18,15 @@ package ads
 
 import (
 	"fmt"
+	"github.com/Flaque/filet"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -39,7 +43,6 @@ func TestBlocklistUpdater(t *testing.T) {
 	p := initTestPlugin(t, getEmptyRuleset())
 
 	p.config.BlacklistURLs = []string{url}
-	p.blacklist = make(ListMap, 0)
 
 	updater := ListUpdater{
 		Enabled:        true,
@@ -52,21 +55,23 @@ func TestBlocklistUpdater(t *testing.T) {
 	p.updater = &updater
 
 	p.updater.Start()
+	defer p.updater.Stop()
 
-	time.Sleep(time.Second * 1)
-	assert.Equal(t, 1000, len(p.blacklist))
+	waitForLists(t, p.updater, 5*time.Second, func(s *listSnapshot) bool {
+		return len(s.http.Blacklist) > 0
+	})
+	assert.Equal(t, 1000, len(p.updater.lists().http.Blacklist))
 
-	time.Sleep(time.Second * 5)
-	assert.Equal(t, 2000, len(p.blacklist))
-
-	p.updater.httpUpdateTicker.Stop()
+	waitForLists(t, p.updater, 10*time.Second, func(s *listSnapshot) bool {
+		return len(s.http.Blacklist) != 1000
+	})
+	assert.Equal(t, 2000, len(p.updater.lists().http.Blacklist))
 }
 
 func TestBlocklistUpdaterWithBadList(t *testing.T) {
 	p := initTestPlugin(t, getEmptyRuleset())
 
 	p.config.BlacklistURLs = []string{"https://badhost/doesnotexist"}
-	p.blacklist = make(ListMap, 0)
 
 	updater := ListUpdater{
 		Enabled:        false,
@@ -78,10 +83,11 @@ func TestBlocklistUpdaterWithBadList(t *testing.T) {
 
 	p.updater = &updater
 	p.updater.Start()
+	defer p.updater.Stop()
 
 	// give it time to fail
 	time.Sleep(time.Second * 6)
-	assert.Equal(t, 0, len(p.blacklist))
+	assert.Equal(t, 0, len(p.updater.lists().http.Blacklist))
 }
 
 func TestBlocklistUpdaterWithBadAndGoodList(t *testing.T) {
@@ -92,7 +98,6 @@ func TestBlocklistUpdaterWithBadAndGoodList(t *testing.T) {
 	p := initTestPlugin(t, getEmptyRuleset())
 
 	p.config.BlacklistURLs = []string{url, "https://badhost/doesnotexist"}
-	p.blacklist = make(ListMap, 0)
 
 	updater := ListUpdater{
 		Enabled:        false,
@@ -104,10 +109,11 @@ func TestBlocklistUpdaterWithBadAndGoodList(t *testing.T) {
 
 	p.updater = &updater
 	p.updater.Start()
+	defer p.updater.Stop()
 
 	// give it time to fail
 	time.Sleep(time.Second * 6)
-	assert.Equal(t, 1000, len(p.blacklist))
+	assert.Equal(t, 1000, len(p.updater.lists().http.Blacklist))
 }
 
 func initTestServer(t *testing.T) *httptest.Server {
@@ -121,12 +127,11 @@ func initTestServer(t *testing.T) *httptest.Server {
 	defer secondPath.Close()
 	secondData, err := ioutil.ReadAll(secondPath)
 
-	firstServed := false
+	var firstServed int32
 
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		if !firstServed {
+		if atomic.CompareAndSwapInt32(&firstServed, 0, 1) {
 			w.Write(firstData)
-			firstServed = true
 		} else {
 			w.Write(secondData)
 		}
@@ -135,3 +140,136 @@ func initTestServer(t *testing.T) *httptest.Server {
 
 	return server
 }
+
+func TestListUpdater_IndependentSources(t *testing.T) {
+	var fastRequests, slowRequests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/fast.txt":
+			n := atomic.AddInt32(&fastRequests, 1)
+			fmt.Fprintf(w, "0.0.0.0 fast-%d.example.com\n", n)
+		case "/slow.txt":
+			atomic.AddInt32(&slowRequests, 1)
+			fmt.Fprintln(w, "0.0.0.0 slow.example.com")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	fast, slow, broken := srv.URL+"/fast.txt", srv.URL+"/slow.txt", srv.URL+"/broken.txt"
+
+	p := initTestPlugin(t, getEmptyRuleset())
+	p.config.BlacklistURLs = []string{fast, slow, broken}
+	p.config.ListSources = map[string]*listSourceConfig{
+		fast:   {URL: fast, UpdateInterval: time.Second},
+		broken: {URL: broken, RetryCount: 2, RetryInterval: 100 * time.Millisecond},
+	}
+
+	p.updater = &ListUpdater{
+		Enabled:        true,
+		Plugin:         p,
+		UpdateInterval: time.Hour,
+		RetryCount:     1,
+		RetryDelay:     time.Second,
+	}
+	p.updater.Start()
+	defer p.updater.Stop()
+
+	waitForLists(t, p.updater, 5*time.Second, func(s *listSnapshot) bool {
+		return s.http.Blacklist["fast-1.example.com"] && s.http.Blacklist["slow.example.com"]
+	})
+
+	// The fast list gets updated without downloading the slow one again
+	waitForLists(t, p.updater, 5*time.Second, func(s *listSnapshot) bool {
+		return s.http.Blacklist["fast-2.example.com"]
+	})
+	assert.True(t, p.updater.lists().http.Blacklist["fast-2.example.com"])
+	assert.False(t, p.updater.lists().http.Blacklist["fast-1.example.com"])
+	assert.True(t, p.updater.lists().http.Blacklist["slow.example.com"])
+	assert.Equal(t, int32(1), atomic.LoadInt32(&slowRequests))
+}
+
+func TestListUpdater_RetryDelay(t *testing.T) {
+	s := &listSourceState{Interval: time.Minute, RetryDelay: time.Second}
+
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		for i := 0; i < 100; i++ {
+			delay := s.retryDelay(attempt)
+			assert.True(t, delay >= expected/2 && delay < expected*3/2, "delay %s for attempt %d", delay, attempt)
+		}
+	}
+
+	// The delay is capped at the update interval
+	for i := 0; i < 100; i++ {
+		delay := s.retryDelay(20)
+		assert.True(t, delay >= 30*time.Second && delay < 90*time.Second)
+	}
+}
+
+func TestListUpdater_PersistedSources(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		fmt.Fprintf(w, "0.0.0.0 %s.example.com\n", strings.Trim(req.URL.Path, "/"))
+	}))
+	defer srv.Close()
+
+	tmpdir := filet.TmpDir(t, "")
+	defer filet.CleanUp(t)
+	storePath := filepath.Join(tmpdir, "store.json.gz")
+
+	first, second := srv.URL+"/first", srv.URL+"/second"
+
+	newUpdater := func(urls ...string) *DNSAdBlock {
+		p := initTestPlugin(t, getEmptyRuleset())
+		p.config.BlacklistURLs = urls
+		p.updater = &ListUpdater{
+			Enabled:         true,
+			Plugin:          p,
+			UpdateInterval:  time.Hour,
+			RetryCount:      1,
+			RetryDelay:      time.Second,
+			persistLists:    true,
+			persistencePath: storePath,
+		}
+		return p
+	}
+
+	p := newUpdater(first)
+	p.updater.Start()
+	assert.Eventually(t, p.updater.isLoaded, 5*time.Second, 50*time.Millisecond)
+	p.updater.Stop()
+	assert.True(t, p.updater.lists().http.Blacklist["first.example.com"])
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	// Only the newly added list has to be downloaded
+	p = newUpdater(first, second)
+	p.updater.Start()
+	assert.Eventually(t, p.updater.isLoaded, 5*time.Second, 50*time.Millisecond)
+	p.updater.Stop()
+	assert.True(t, p.updater.lists().http.Blacklist["first.example.com"])
+	assert.True(t, p.updater.lists().http.Blacklist["second.example.com"])
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	stored, err := ReadListConfiguration(storePath)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(stored.Sources))
+}
+
+// waitForLists waits until the lists published by the updater satisfy cond.
+func waitForLists(t *testing.T, u *ListUpdater, timeout time.Duration, cond func(s *listSnapshot) bool) {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		updated := u.updated()
+		if cond(u.lists()) {
+			return
+		}
+		select {
+		case <-updated:
+		case <-deadline:
+			t.Fatal("Timed out waiting for the lists to be published")
+		}
+	}
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+var errListVerification = errors.New("list verification failed")
+
+// listVerification describes how the integrity of a list gets verified.
+// A list can be pinned to a SHA-256 checksum, verified using a detached
+// signature, or both.
+type listVerification struct {
+	SHA256       []byte
+	SignatureURL string
+	PublicKey    *signaturePublicKey
+}
+
+// signaturePublicKey is either a minisign public key or a plain Ed25519 key.
+// Plain keys have no key ID and verify plain Ed25519 signatures.
+type signaturePublicKey struct {
+	KeyID []byte
+	Key   ed25519.PublicKey
+}
+
+func parseSHA256(v string) ([]byte, error) {
+	sum, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(v), "sha256:"))
+	if err != nil || len(sum) != sha256.Size {
+		return nil, fmt.Errorf("invalid SHA-256 checksum %q", v)
+	}
+	return sum, nil
+}
+
+func parsePublicKey(v string) (*signaturePublicKey, error) {
+	data, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key %q", v)
+	}
+
+	switch {
+	case len(data) == ed25519.PublicKeySize:
+		return &signaturePublicKey{Key: data}, nil
+	case len(data) == 2+8+ed25519.PublicKeySize && string(data[:2]) == "Ed":
+		return &signaturePublicKey{KeyID: data[2:10], Key: data[10:]}, nil
+	}
+	return nil, fmt.Errorf("invalid public key %q, expected a minisign or Ed25519 public key", v)
+}
+
+func (k *signaturePublicKey) verify(data, signature []byte) error {
+	if k.KeyID == nil {
+		return k.verifyEd25519(data, signature)
+	}
+	return k.verifyMinisign(data, signature)
+}
+
+func (k *signaturePublicKey) verifyEd25519(data, signature []byte) error {
+	sig := signature
+	if len(sig) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+		if err != nil {
+			return fmt.Errorf("%w: malformed signature", errListVerification)
+		}
+		sig = decoded
+	}
+	if len(sig) != ed25519.SignatureSize || !ed25519.Verify(k.Key, data, sig) {
+		return fmt.Errorf("%w: invalid signature", errListVerification)
+	}
+	return nil
+}
+
+// verifyMinisign verifies a signature in the format created by minisign,
+// including the signature of the trusted comment.
+func (k *signaturePublicKey) verifyMinisign(data, signature []byte) error {
+	lines := strings.Split(strings.TrimSpace(strings.Replace(string(signature), "\r", "", -1)), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return fmt.Errorf("%w: malformed minisign signature", errListVerification)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(sig) != 2+8+ed25519.SignatureSize {
+		return fmt.Errorf("%w: malformed minisign signature", errListVerification)
+	}
+	globalSig, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(globalSig) != ed25519.SignatureSize {
+		return fmt.Errorf("%w: malformed minisign signature", errListVerification)
+	}
+
+	algorithm, keyID, listSig := string(sig[:2]), sig[2:10], sig[10:]
+	if !bytes.Equal(keyID, k.KeyID) {
+		return fmt.Errorf("%w: signature has been created with key %X, expected %X", errListVerification, keyID, k.KeyID)
+	}
+
+	message := data
+	switch algorithm {
+	case "Ed":
+	case "ED":
+		sum := blake2b.Sum512(data)
+		message = sum[:]
+	default:
+		return fmt.Errorf("%w: unsupported signature algorithm %q", errListVerification, algorithm)
+	}
+
+	if !ed25519.Verify(k.Key, message, listSig) {
+		return fmt.Errorf("%w: invalid signature", errListVerification)
+	}
+
+	trustedComment := strings.TrimPrefix(lines[2], "trusted comment: ")
+	if !ed25519.Verify(k.Key, append(append([]byte{}, listSig...), trustedComment...), globalSig) {
+		return fmt.Errorf("%w: invalid signature of trusted comment", errListVerification)
+	}
+	return nil
+}
+
+func (f *ListFetcher) verify(ctx context.Context, listUrl string, data []byte) error {
+	source := f.Lists[listUrl]
+	if source == nil || source.Verification == nil {
+		return nil
+	}
+	v := source.Verification
+
+	if v.SHA256 != nil {
+		sum := sha256.Sum256(data)
+		if !bytes.Equal(sum[:], v.SHA256) {
+			return fmt.Errorf("%w: expected SHA-256 %x, got %x", errListVerification, v.SHA256, sum)
+		}
+	}
+
+	if v.PublicKey != nil {
+		// Signatures are downloaded using the HTTP options of the list
+		var signatureFetcher listFetchFunc = f.fetchHTTP
+		if strings.HasPrefix(v.SignatureURL, "file://") {
+			signatureFetcher = f.fetchFile
+		} else if client := f.Clients[listUrl]; client != nil {
+			signatureFetcher = func(ctx context.Context, ref string) (*fetchedList, error) {
+				return f.fetchHTTPWithClient(ctx, client, ref)
+			}
+		}
+
+		signature, err := f.fetch(ctx, v.SignatureURL, signatureFetcher)
+		if err != nil {
+			return fmt.Errorf("%w: fetching signature %q failed: %s", errListVerification, v.SignatureURL, err.Error())
+		}
+		if err := v.PublicKey.verify(data, signature.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/blake2b"
+)
+
+const testList = "0.0.0.0 ads.example.com\n0.0.0.0 tracker.example.com\n"
+const tamperedList = "0.0.0.0 ads.example.com\n0.0.0.0 www.bank.example\n"
+
+func TestVerification_SHA256(t *testing.T) {
+	served := testList
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, served)
+	}))
+	defer srv.Close()
+
+	sum := sha256.Sum256([]byte(testList))
+	pinned, err := parseSHA256(hex.EncodeToString(sum[:]))
+	assert.NoError(t, err)
+
+	fetcher := &ListFetcher{
+		Workers: 1,
+		Lists:   map[string]*listSourceConfig{srv.URL: {URL: srv.URL, Verification: &listVerification{SHA256: pinned}}},
+	}
+
+	list, err := fetcher.GenerateListMapFromHTTPUrls(context.Background(), []string{srv.URL})
+	assert.NoError(t, err)
+	assert.True(t, list["tracker.example.com"])
+
+	// The tampered list must be rejected, the previous one is kept
+	served = tamperedList
+	list, err = fetcher.GenerateListMapFromHTTPUrls(context.Background(), []string{srv.URL})
+	assert.NoError(t, err)
+	assert.True(t, list["tracker.example.com"])
+	assert.False(t, list["www.bank.example"])
+
+	// Without a verified version the list is dropped completely
+	fetcher.lastVerified = nil
+	list, err = fetcher.GenerateListMapFromHTTPUrls(context.Background(), []string{srv.URL})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(list))
+
+	// Other errors do not fall back to the last verified version
+	served = testList
+	_, err = fetcher.GenerateListMapFromHTTPUrls(context.Background(), []string{srv.URL})
+	assert.NoError(t, err)
+	fetcher.MaxSize = 16
+	list, err = fetcher.GenerateListMapFromHTTPUrls(context.Background(), []string{srv.URL})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(list))
+}
+
+func TestVerification_Minisign(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	keyID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	served := testList
+	signature := minisign(priv, keyID, "ED", []byte(testList))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/list.txt.minisig" {
+			fmt.Fprint(w, signature)
+			return
+		}
+		fmt.Fprint(w, served)
+	}))
+	defer srv.Close()
+
+	encodedKey := base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID...), pub...))
+	c := caddy.NewTestController("dns", fmt.Sprintf("ads {\n blacklist %s/list.txt {\n public-key %s\n }\n}", srv.URL, encodedKey))
+	c.Next()
+	cfg, err := parsePluginConfiguration(c)
+	assert.NoError(t, err)
+
+	fetcher, err := newListFetcher(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, srv.URL+"/list.txt.minisig", fetcher.Lists[srv.URL+"/list.txt"].Verification.SignatureURL)
+
+	list, err := fetcher.GenerateListMapFromHTTPUrls(context.Background(), cfg.BlacklistURLs)
+	assert.NoError(t, err)
+	assert.True(t, list["tracker.example.com"])
+
+	served = tamperedList
+	list, err = fetcher.GenerateListMapFromHTTPUrls(context.Background(), cfg.BlacklistURLs)
+	assert.NoError(t, err)
+	assert.True(t, list["tracker.example.com"])
+	assert.False(t, list["www.bank.example"])
+
+	key, err := parsePublicKey(encodedKey)
+	assert.NoError(t, err)
+	assert.NoError(t, key.verify([]byte(testList), []byte(minisign(priv, keyID, "Ed", []byte(testList)))))
+	assert.Error(t, key.verify([]byte(testList), []byte(minisign(priv, []byte("otherkey"), "Ed", []byte(testList)))))
+	assert.Error(t, key.verify([]byte(testList), []byte("not a signature")))
+}
+
+func TestVerification_Ed25519(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+
+	key, err := parsePublicKey(base64.StdEncoding.EncodeToString(pub))
+	assert.NoError(t, err)
+
+	sig := ed25519.Sign(priv, []byte(testList))
+	assert.NoError(t, key.verify([]byte(testList), sig))
+	assert.NoError(t, key.verify([]byte(testList), []byte(base64.StdEncoding.EncodeToString(sig)+"\n")))
+	assert.Error(t, key.verify([]byte(tamperedList), sig))
+}
+
+func TestVerification_InvalidOptions(t *testing.T) {
+	cfs := []string{
+		"ads {\n blacklist https://lists.local/list.txt {\n sha256 abcdef\n }\n}",
+		"ads {\n blacklist https://lists.local/list.txt {\n public-key not-a-key\n }\n}",
+		"ads {\n blacklist https://lists.local/list.txt {\n signature https://lists.local/list.txt.sig\n }\n}",
+	}
+
+	for _, v := range cfs {
+		c := caddy.NewTestController("dns", v)
+		c.Next()
+		_, err := parsePluginConfiguration(c)
+		assert.Error(t, err)
+	}
+}
+
+func minisign(priv ed25519.PrivateKey, keyID []byte, algorithm string, data []byte) string {
+	message := data
+	if algorithm == "ED" {
+		sum := blake2b.Sum512(data)
+		message = sum[:]
+	}
+	sig := ed25519.Sign(priv, message)
+	trustedComment := "timestamp:1600000000\tfile:list.txt"
+	globalSig := ed25519.Sign(priv, append(append([]byte{}, sig...), trustedComment...))
+
+	return fmt.Sprintf("untrusted comment: signature from minisign secret key\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(append(append([]byte(algorithm), keyID...), sig...)),
+		trustedComment,
+		base64.StdEncoding.EncodeToString(globalSig))
+}
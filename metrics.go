@@ -20,7 +20,6 @@ import (
 	"github.com/coredns/coredns/plugin"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
-	"sync"
 )
 
 var requestCountTotal = promauto.NewCounterVec(prometheus.CounterOpts{
@@ -44,4 +43,30 @@ var blockedRequestCount = promauto.NewGaugeVec(prometheus.GaugeOpts{
     Help:      "Counter of requests blocked by this plugin.",
 }, []string{"server"})
 
-var once sync.Once
+var blockedResponseCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: "ads",
+	Name:      "blocked_response_count_total",
+	Help:      "Total counter of upstream responses blocked by this plugin, labeled by the reason of the block.",
+}, []string{"server", "reason"})
+
+var blockedCategoryCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: "ads",
+	Name:      "blocked_category_count_total",
+	Help:      "Total counter of blocked requests by the category of the lists containing the blocked name.",
+}, []string{"server", "category"})
+
+var fallbackListActive = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: "ads",
+	Name:      "fallback_list_active",
+	Help:      "Number of configurations using the embedded fallback list, because no blacklist has been loaded yet.",
+})
+
+var listVerificationFailureCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: "ads",
+	Name:      "list_verification_failure_count_total",
+	Help:      "Total counter of list downloads rejected by the integrity verification.",
+}, []string{"list"})
@@ -0,0 +1,134 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// NetworkSet holds networks used to block answers resolving to known bad
+// addresses. Networks are grouped by prefix length, so a lookup needs one
+// map access per prefix length in use instead of comparing all networks.
+type NetworkSet struct {
+	networks map[int]map[string]bool
+}
+
+func NewNetworkSet() *NetworkSet {
+	return &NetworkSet{networks: make(map[int]map[string]bool)}
+}
+
+// networkSetFromList builds a set from a list created by parseNetworkListFile.
+func networkSetFromList(list ListMap) *NetworkSet {
+	set := NewNetworkSet()
+	for k := range list {
+		if network, err := parseNetwork(k); err == nil {
+			set.Add(network)
+		}
+	}
+	return set
+}
+
+func (s *NetworkSet) Add(network *net.IPNet) {
+	ones, bits := network.Mask.Size()
+	// IPv4 and IPv6 networks of the same prefix length are kept apart
+	key := ones
+	if bits == 8*net.IPv6len {
+		key += 8*net.IPv4len + 1
+	}
+	if s.networks[key] == nil {
+		s.networks[key] = make(map[string]bool)
+	}
+	s.networks[key][string(network.IP)] = true
+}
+
+func (s *NetworkSet) Contains(ip net.IP) bool {
+	if s == nil {
+		return false
+	}
+	bits, offset := 8*net.IPv4len, 0
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	} else {
+		bits, offset = 8*net.IPv6len, 8*net.IPv4len+1
+	}
+	for key, networks := range s.networks {
+		ones := key - offset
+		if ones < 0 || ones > bits {
+			continue
+		}
+		if networks[string(ip.Mask(net.CIDRMask(ones, bits)))] {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *NetworkSet) Len() int {
+	if s == nil {
+		return 0
+	}
+	count := 0
+	for _, networks := range s.networks {
+		count += len(networks)
+	}
+	return count
+}
+
+// parseNetwork parses a network in CIDR notation or a single address.
+func parseNetwork(v string) (*net.IPNet, error) {
+	if !strings.Contains(v, "/") {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", v)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(8*net.IPv4len, 8*net.IPv4len)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(8*net.IPv6len, 8*net.IPv6len)}, nil
+	}
+
+	_, network, err := net.ParseCIDR(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network %q", v)
+	}
+	return network, nil
+}
+
+// parseNetworkListFile parses a list containing one address or network in
+// CIDR notation per line. Comments and invalid lines are skipped.
+func parseNetworkListFile(data []byte, networks ListMap) {
+	count := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		network, err := parseNetwork(fields[0])
+		if err != nil {
+			continue
+		}
+		networks[network.String()] = true
+		count++
+	}
+	log.Debugf("Fetched %d networks.", count)
+}
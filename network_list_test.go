@@ -0,0 +1,76 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetworkSet_Contains(t *testing.T) {
+	list := make(ListMap, 0)
+	parseNetworkListFile([]byte("# Bad networks\n192.0.2.0/24\n198.51.100.7 ; sinkhole\n2001:db8:bad::/48\ninvalid\n10.0.0.0/33\n"), list)
+	assert.Len(t, list, 3)
+
+	set := networkSetFromList(list)
+	assert.Equal(t, 3, set.Len())
+	assert.True(t, set.Contains(net.ParseIP("192.0.2.1")))
+	assert.True(t, set.Contains(net.ParseIP("::ffff:192.0.2.1")))
+	assert.True(t, set.Contains(net.ParseIP("198.51.100.7")))
+	assert.True(t, set.Contains(net.ParseIP("2001:db8:bad::1")))
+	assert.False(t, set.Contains(net.ParseIP("198.51.100.8")))
+	assert.False(t, set.Contains(net.ParseIP("2001:db8:beef::1")))
+
+	var empty *NetworkSet
+	assert.False(t, empty.Contains(net.ParseIP("192.0.2.1")))
+}
+
+func TestLookup_BlockedNetwork(t *testing.T) {
+	ruleset := getEmptyRuleset()
+	network, err := parseNetwork("192.0.2.0/24")
+	assert.NoError(t, err)
+	ruleset.AddNetworkToBlacklist(network)
+	ruleset.AddToWhitelist("permitted.test.tld")
+
+	p := initTestPlugin(t, ruleset)
+	p.Next = upstreamHandler(map[string][]string{
+		"bad.test.tld.":       {"bad.test.tld. 3600 IN A 192.0.2.10"},
+		"good.test.tld.":      {"good.test.tld. 3600 IN A 203.0.113.10"},
+		"permitted.test.tld.": {"permitted.test.tld. 3600 IN A 192.0.2.10"},
+	})
+
+	testCases := []test.Case{
+		{
+			Qname: "bad.test.tld", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("bad.test.tld. 60 IN A 10.1.33.7")},
+		},
+		{
+			Qname: "good.test.tld", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("good.test.tld. 3600 IN A 203.0.113.10")},
+		},
+		{
+			Qname: "permitted.test.tld", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("permitted.test.tld. 3600 IN A 192.0.2.10")},
+		},
+	}
+	resolveTestCases(testCases, p, context.TODO(), t)
+}
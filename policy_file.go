@@ -0,0 +1,405 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/caddy/caddyfile"
+	"github.com/coredns/coredns/plugin"
+	"gopkg.in/yaml.v3"
+)
+
+// policy is the content of a policy file. Every option is translated into
+// the corresponding Corefile option, so both are validated the same way.
+type policy struct {
+	Use        []policyValue    `yaml:"use"`
+	Lists      []policyValue    `yaml:"lists"`
+	Categories policyCategories `yaml:"categories"`
+	Sources    []policySource   `yaml:"sources"`
+	Rules      policyRules      `yaml:"rules"`
+	Response   policyResponse   `yaml:"response"`
+
+	// Groups are rejected explicitly, the plugin has no notion of clients
+	Groups yaml.Node `yaml:"groups"`
+}
+
+type policyCategories struct {
+	Block  []policyValue `yaml:"block"`
+	Permit []policyValue `yaml:"permit"`
+}
+
+type policySource struct {
+	URL        policyValue   `yaml:"url"`
+	Kind       policyValue   `yaml:"kind"`
+	Categories []policyValue `yaml:"categories"`
+	Action     policyValue   `yaml:"action"`
+}
+
+type policyRules struct {
+	Block       []policyRule  `yaml:"block"`
+	BlockRegex  []policyRule  `yaml:"block-regex"`
+	Permit      []policyValue `yaml:"permit"`
+	PermitRegex []policyValue `yaml:"permit-regex"`
+	BlockIP     []policyValue `yaml:"block-ip"`
+	CnameCloak  []policyValue `yaml:"cname-cloak"`
+}
+
+type policyResponse struct {
+	Mode       policyValue `yaml:"mode"`
+	Target     policyValue `yaml:"target"`
+	TargetIPv6 policyValue `yaml:"target-ipv6"`
+	BlockPage  policyValue `yaml:"block-page"`
+	TTL        policyValue `yaml:"ttl"`
+}
+
+// policyValue is a scalar value of the policy file, which keeps its line
+// for error messages.
+type policyValue struct {
+	Value string
+	Line  int
+}
+
+func (v *policyValue) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: expected a single value", node.Line)
+	}
+	v.Value, v.Line = node.Value, node.Line
+	return nil
+}
+
+// policyRule is either a name or a name with its own action.
+type policyRule struct {
+	Name   policyValue
+	Action policyValue
+}
+
+func (r *policyRule) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return r.Name.UnmarshalYAML(node)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		var target *policyValue
+		switch key := node.Content[i].Value; key {
+		case "name":
+			target = &r.Name
+		case "action":
+			target = &r.Action
+		default:
+			return fmt.Errorf("line %d: unknown rule option %q, expected name or action", node.Content[i].Line, key)
+		}
+		if err := target.UnmarshalYAML(node.Content[i+1]); err != nil {
+			return err
+		}
+	}
+	if r.Name.Value == "" {
+		return fmt.Errorf("line %d: the rule has no name", node.Line)
+	}
+	return nil
+}
+
+// policySourceKinds maps the kinds of policy sources to their option
+var policySourceKinds = map[string]string{
+	"":                    "blacklist",
+	"blacklist":           "blacklist",
+	"whitelist":           "whitelist",
+	"ip-blacklist":        "ip-blacklist",
+	"cname-cloaking-list": "cname-cloaking-list",
+}
+
+// loadPolicy reads a policy file. YAML and JSON files are supported,
+// unknown options are rejected.
+func loadPolicy(path string) (*policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &policy{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(p); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("Invalid policy file %q: %s", path, err.Error())
+	}
+	if p.Groups.Kind != 0 {
+		return nil, fmt.Errorf("Invalid policy file %q: line %d: client groups are not supported, "+
+			"use list sets to share lists between server blocks", path, p.Groups.Line)
+	}
+	return p, nil
+}
+
+// policyTokens builds the Corefile tokens of a policy. The tokens use the
+// line of their value in the policy file, as long as every option still
+// starts on a new line.
+type policyTokens struct {
+	file   string
+	line   int
+	tokens []caddyfile.Token
+}
+
+func (t *policyTokens) add(line int, words ...string) {
+	if line <= t.line {
+		line = t.line + 1
+	}
+	t.line = line
+	for _, w := range words {
+		t.tokens = append(t.tokens, caddyfile.Token{File: t.file, Line: line, Text: w})
+	}
+}
+
+func (t *policyTokens) values(option string, values []policyValue) {
+	for _, v := range values {
+		t.add(v.Line, option, v.Value)
+	}
+}
+
+// rule adds a rule with its optional action block.
+func (t *policyTokens) rule(option string, line int, name string, action policyValue) {
+	if action.Value == "" {
+		t.add(line, option, name)
+		return
+	}
+	t.add(line, option, name, "{")
+	t.add(action.Line, append([]string{"action"}, strings.Fields(action.Value)...)...)
+	t.add(0, "}")
+}
+
+func (t *policyTokens) errorf(line int, format string, args ...interface{}) error {
+	msg := fmt.Sprintf("%s:%d - Error during parsing: %s", t.file, line, fmt.Sprintf(format, args...))
+	return plugin.Error("ads", errors.New(msg))
+}
+
+// applyPolicyFile adds the options of the policy file to the configuration.
+func (cfg *adsPluginConfig) applyPolicyFile() error {
+	p, err := loadPolicy(cfg.PolicyFile)
+	if err != nil {
+		return plugin.Error("ads", err)
+	}
+
+	t := &policyTokens{file: cfg.PolicyFile}
+	t.add(0, "policy", "{")
+
+	for _, v := range p.Use {
+		t.add(v.Line, "use", v.Value)
+	}
+	t.values("list", p.Lists)
+	t.values("block-category", p.Categories.Block)
+	t.values("permit-category", p.Categories.Permit)
+	for _, s := range p.Sources {
+		option, ok := policySourceKinds[s.Kind.Value]
+		if !ok {
+			return t.errorf(s.Kind.Line, "Unknown list kind %q, expected blacklist, whitelist, ip-blacklist or cname-cloaking-list", s.Kind.Value)
+		}
+		if s.URL.Value == "" {
+			return t.errorf(t.line+1, "The list has no URL")
+		}
+		if len(s.Categories) == 0 && s.Action.Value == "" {
+			t.add(s.URL.Line, option, s.URL.Value)
+			continue
+		}
+		t.add(s.URL.Line, option, s.URL.Value, "{")
+		if len(s.Categories) > 0 {
+			words := []string{"category"}
+			for _, v := range s.Categories {
+				words = append(words, v.Value)
+			}
+			t.add(s.Categories[0].Line, words...)
+		}
+		if s.Action.Value != "" {
+			t.add(s.Action.Line, append([]string{"action"}, strings.Fields(s.Action.Value)...)...)
+		}
+		t.add(0, "}")
+	}
+
+	for _, r := range p.Rules.Block {
+		t.rule("block", r.Name.Line, r.Name.Value, r.Action)
+	}
+	for _, r := range p.Rules.BlockRegex {
+		t.rule("block-regex", r.Name.Line, r.Name.Value, r.Action)
+	}
+	t.values("permit", p.Rules.Permit)
+	t.values("permit-regex", p.Rules.PermitRegex)
+	t.values("block-ip", p.Rules.BlockIP)
+	t.values("cname-cloak", p.Rules.CnameCloak)
+
+	// The response mode of the policy replaces the one of the Corefile
+	response := p.Response
+	switch response.Mode.Value {
+	case "":
+	case responseModeNXDomain:
+		cfg.BlockPageHost = ""
+		t.add(response.Mode.Line, "nxdomain")
+	case responseModeTarget:
+		cfg.WriteNXDomain, cfg.BlockPageHost = false, ""
+	case responseModeBlockPage:
+		cfg.WriteNXDomain = false
+		if response.BlockPage.Value == "" && cfg.BlockPageHost == "" {
+			return t.errorf(response.Mode.Line, "No host for the block page defined")
+		}
+	default:
+		return t.errorf(response.Mode.Line, "Unknown response mode %q, expected nxdomain, target or block-page", response.Mode.Value)
+	}
+	if response.BlockPage.Value != "" {
+		if response.Mode.Value != responseModeBlockPage {
+			return t.errorf(response.BlockPage.Line, "The block page host requires the block-page response mode")
+		}
+		t.add(response.BlockPage.Line, "block-page", response.BlockPage.Value)
+	}
+	if response.Target.Value != "" {
+		t.add(response.Target.Line, "target", response.Target.Value)
+	}
+	if response.TargetIPv6.Value != "" {
+		t.add(response.TargetIPv6.Line, "target-ipv6", response.TargetIPv6.Value)
+	}
+	if response.TTL.Value != "" {
+		t.add(response.TTL.Line, "ttl", response.TTL.Value)
+	}
+	t.add(0, "}")
+
+	c := &caddy.Controller{Dispenser: caddyfile.NewDispenserTokens(cfg.PolicyFile, t.tokens)}
+	c.Next()
+	return parseOptions(c, cfg, cfg.listSets, nil)
+}
+
+// reloadPolicy builds the configuration using the current content of the
+// policy file.
+func (cfg *adsPluginConfig) reloadPolicy() (*adsPluginConfig, error) {
+	config := cfg.policyBase.clone()
+	config.policyBase = cfg.policyBase
+	config.listSets = cfg.listSets
+	if err := config.applyPolicyFile(); err != nil {
+		return nil, err
+	}
+	if err := config.resolve(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// hasSameSources returns true if both configurations load the same lists
+// with the same options, so they can share a list updater.
+func (cfg *adsPluginConfig) hasSameSources(other *adsPluginConfig) bool {
+	sources := func(c *adsPluginConfig) []interface{} {
+		return []interface{}{
+			c.BlacklistURLs, c.WhitelistURLs, c.NetworkBlacklistURLs, c.CnameCloakingURLs,
+			c.BlacklistFiles, c.WhitelistFiles, c.NetworkBlacklistFiles, c.CnameCloakingFiles,
+			c.ListSources, c.HTTPClient, c.FileListRenewalInterval,
+		}
+	}
+	return reflect.DeepEqual(sources(cfg), sources(other))
+}
+
+// policyWatcher applies the policy file again whenever it changes. Invalid
+// policies are rejected, the current policy stays active in this case.
+type policyWatcher struct {
+	Plugin *DNSAdBlock
+
+	cancel   context.CancelFunc
+	routines sync.WaitGroup
+}
+
+func (w *policyWatcher) Start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	changes, err := watchFiles(ctx, []string{w.Plugin.config.PolicyFile})
+	if err != nil {
+		cancel()
+		return plugin.Error("ads", fmt.Errorf("Watching the policy file failed: %s", err.Error()))
+	}
+	w.cancel = cancel
+
+	w.routines.Add(1)
+	go func() {
+		defer w.routines.Done()
+		for {
+			select {
+			case <-changes:
+				w.reload()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop terminates the watcher and the list updater of the active policy.
+func (w *policyWatcher) Stop() {
+	if w.cancel != nil {
+		w.cancel()
+	}
+	w.routines.Wait()
+	w.Plugin.active().updater.Stop()
+}
+
+// reload publishes a new instance of the plugin using the changed policy.
+// Queries are answered by either the current or the new instance, never by
+// a mix of both.
+func (w *policyWatcher) reload() {
+	current := w.Plugin.active()
+	cfg, err := current.config.reloadPolicy()
+	if err != nil {
+		log.Errorf("Applying the policy file %q failed, keeping the current policy: %s", current.config.PolicyFile, err.Error())
+		return
+	}
+	ruleset, err := buildRulesetFromConfig(cfg)
+	if err != nil {
+		log.Errorf("Applying the policy file %q failed, keeping the current policy: %s", current.config.PolicyFile, err.Error())
+		return
+	}
+
+	next := &DNSAdBlock{
+		Next:              current.Next,
+		ConfiguredRuleSet: *ruleset,
+		updater:           current.updater,
+		config:            cfg,
+	}
+	if cfg.hasSameSources(current.config) {
+		w.Plugin.policy.Store(next)
+		log.Infof("Applied the policy file %q", cfg.PolicyFile)
+		return
+	}
+
+	if next.updater, err = newListUpdater(cfg); err != nil {
+		log.Errorf("Applying the policy file %q failed, keeping the current policy: %s", current.config.PolicyFile, err.Error())
+		return
+	}
+	next.updater.Plugin = next
+	// The lists of the current policy are used until the new ones are
+	// loaded, so the plugin does not become unready
+	lists := current.updater.lists()
+	next.updater.publish(func(s *listSnapshot) {
+		*s = *lists
+	})
+	atomic.StoreInt32(&next.updater.loaded, 1)
+
+	// Lists used by both policies are shared, so they are not fetched again.
+	// The previous updater only publishes to the previous instance.
+	next.updater.Start()
+	w.Plugin.policy.Store(next)
+	current.updater.Stop()
+	log.Infof("Applied the policy file %q", cfg.PolicyFile)
+}
@@ -0,0 +1,244 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Flaque/filet"
+	"github.com/coredns/caddy"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPolicy = `
+lists: [adaway]
+categories:
+  permit: [social]
+sources:
+  - url: https://lists.local/adult.txt
+    categories: [adult]
+    action: nxdomain
+  - url: https://lists.local/allowed.txt
+    kind: whitelist
+rules:
+  block:
+    - ads.example.com
+    - name: casino.example.com
+      action: block-page blocked.example.com
+  block-regex: ['(^|\.)tracker\.']
+  permit: [cdn.example.com]
+response:
+  target: 10.0.0.1
+  ttl: 5m
+`
+
+func writePolicy(t *testing.T, content string) string {
+	path := filepath.Join(filet.TmpDir(t, ""), "ads.yaml")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func parsePolicyConfiguration(t *testing.T, path string) (*adsPluginConfig, error) {
+	c := caddy.NewTestController("dns", fmt.Sprintf(`ads {
+  blacklist https://lists.local/corefile.txt
+  block corefile.example.com
+  target 10.0.0.2
+  policy-file %s
+}`, path))
+	c.Next()
+	return parsePluginConfiguration(c)
+}
+
+func TestPolicyFile_Parse(t *testing.T) {
+	defer filet.CleanUp(t)
+
+	cfg, err := parsePolicyConfiguration(t, writePolicy(t, testPolicy))
+	assert.NoError(t, err)
+	assert.Contains(t, cfg.BlacklistURLs, "https://lists.local/corefile.txt")
+	assert.Contains(t, cfg.BlacklistURLs, "https://lists.local/adult.txt")
+	assert.Contains(t, cfg.BlacklistURLs, "https://adaway.org/hosts.txt")
+	assert.Equal(t, []string{"https://lists.local/allowed.txt"}, cfg.WhitelistURLs)
+	assert.Equal(t, []string{"adult"}, cfg.ListSources["https://lists.local/adult.txt"].Categories)
+	assert.Equal(t, responseModeNXDomain, cfg.ListSources["https://lists.local/adult.txt"].Action.Mode)
+	assert.True(t, cfg.PermittedCategories[categorySocial])
+
+	assert.Equal(t, []string{"corefile.example.com", "ads.example.com", "casino.example.com"}, cfg.BlacklistRules)
+	assert.Equal(t, "blocked.example.com.", cfg.BlacklistRuleActions["casino.example.com"].BlockPageHost)
+	assert.Equal(t, []string{`(^|\.)tracker\.`}, cfg.RegexBlacklistRules)
+	assert.Equal(t, []string{"cdn.example.com"}, cfg.WhitelistRules)
+	assert.Equal(t, "10.0.0.1", cfg.TargetIP.String())
+	assert.Equal(t, uint32(300), cfg.TargetTTL)
+
+	// The options of the Corefile are kept
+	assert.Equal(t, []string{"corefile.example.com"}, cfg.policyBase.BlacklistRules)
+	assert.Equal(t, "10.0.0.2", cfg.policyBase.TargetIP.String())
+}
+
+func TestPolicyFile_JSON(t *testing.T) {
+	defer filet.CleanUp(t)
+
+	cfg, err := parsePolicyConfiguration(t, writePolicy(t, `{"rules": {"block": ["a.example.com", "b.example.com"]}, "response": {"mode": "nxdomain"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"corefile.example.com", "a.example.com", "b.example.com"}, cfg.BlacklistRules)
+	assert.True(t, cfg.WriteNXDomain)
+}
+
+func TestPolicyFile_Invalid(t *testing.T) {
+	defer filet.CleanUp(t)
+
+	for _, v := range []string{
+		"rule:\n  block: [a.example.com]\n",
+		"rules:\n  block: [{name: a.example.com, response: nxdomain}]\n",
+		"rules:\n  block: [{action: nxdomain}]\n",
+		"rules:\n  permit: [[a.example.com]]\n",
+		"rules:\n  block: [{name: a.example.com, action: redirect}]\n",
+		"sources:\n  - url: https://lists.local/list.txt\n    kind: blocklist\n",
+		"sources:\n  - url: ftp://lists.local/list.txt\n",
+		"sources:\n  - kind: whitelist\n",
+		"lists: [unknown-list]\n",
+		"use: [unknown]\n",
+		"categories:\n  block: [adult]\n  permit: [adult]\n",
+		"response:\n  mode: refused\n",
+		"response:\n  mode: block-page\n",
+		"response:\n  block-page: blocked.example.com\n",
+		"response:\n  target: 10.0.0\n",
+		"{",
+	} {
+		_, err := parsePolicyConfiguration(t, writePolicy(t, v))
+		assert.Error(t, err, v)
+	}
+
+	_, err := parsePolicyConfiguration(t, "/nonexistent/ads.yaml")
+	assert.Error(t, err)
+
+	// Expressions are compiled when the ruleset is built
+	cfg, err := parsePolicyConfiguration(t, writePolicy(t, "rules:\n  block-regex: ['(']\n"))
+	assert.NoError(t, err)
+	_, err = buildRulesetFromConfig(cfg)
+	assert.Error(t, err)
+}
+
+func TestPolicyFile_Reload(t *testing.T) {
+	defer filet.CleanUp(t)
+
+	path := writePolicy(t, testPolicy)
+	cfg, err := parsePolicyConfiguration(t, path)
+	assert.NoError(t, err)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("rules:\n  block: [other.example.com]\n"), 0644))
+	reloaded, err := cfg.reloadPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"corefile.example.com", "other.example.com"}, reloaded.BlacklistRules)
+	assert.Equal(t, []string{"https://lists.local/corefile.txt"}, reloaded.BlacklistURLs)
+	assert.Equal(t, "10.0.0.2", reloaded.TargetIP.String())
+	assert.False(t, reloaded.hasSameSources(cfg))
+
+	// The policy can be reloaded multiple times
+	reloaded, err = reloaded.reloadPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"corefile.example.com", "other.example.com"}, reloaded.BlacklistRules)
+
+	// The previous configuration is unchanged
+	assert.Contains(t, cfg.BlacklistURLs, "https://lists.local/adult.txt")
+	assert.Equal(t, []string{"corefile.example.com"}, cfg.policyBase.BlacklistRules)
+}
+
+func TestPolicyFile_Watch(t *testing.T) {
+	defer filet.CleanUp(t)
+	server, count := listCountingServer(t)
+	defer server.Close()
+
+	path := writePolicy(t, "rules:\n  block: [first.example.com]\n")
+	c := caddy.NewTestController("dns", fmt.Sprintf(`ads {
+  blacklist %s/corefile
+  policy-file %s
+}`, server.URL, path))
+	c.Next()
+	cfg, err := parsePluginConfiguration(c)
+	assert.NoError(t, err)
+
+	p := initTestPlugin(t, getEmptyRuleset())
+	p.config = cfg
+	ruleset, err := buildRulesetFromConfig(cfg)
+	assert.NoError(t, err)
+	p.ConfiguredRuleSet = *ruleset
+	p.updater, err = newListUpdater(cfg)
+	assert.NoError(t, err)
+	p.updater.Plugin = p
+
+	watcher := &policyWatcher{Plugin: p}
+	p.updater.Start()
+	assert.NoError(t, watcher.Start())
+	defer watcher.Stop()
+	assert.Eventually(t, func() bool {
+		return p.ShouldBlock("corefile.example.com")
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.True(t, p.ShouldBlock("first.example.com"))
+
+	// Invalid policies are ignored
+	initial := p.active().updater
+	assert.NoError(t, ioutil.WriteFile(path, []byte("rules: [\n"), 0644))
+	time.Sleep(4 * fileChangeDebounce)
+	assert.True(t, p.ShouldBlock("first.example.com"))
+
+	policy := fmt.Sprintf("rules:\n  block: [second.example.com]\nsources:\n  - url: %s/policy\n", server.URL)
+	assert.NoError(t, ioutil.WriteFile(path+".tmp", []byte(policy), 0644))
+	assert.NoError(t, os.Rename(path+".tmp", path))
+	assert.Eventually(t, func() bool {
+		return p.ShouldBlock("policy.example.com")
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.True(t, p.ShouldBlock("second.example.com"))
+	assert.False(t, p.ShouldBlock("first.example.com"))
+	assert.True(t, p.ShouldBlock("corefile.example.com"))
+	assert.True(t, p.Ready())
+
+	// The new updater took over the lists of the previous one
+	assert.NotEqual(t, initial, p.active().updater)
+	assert.Error(t, initial.ctx.Err())
+	assert.Equal(t, 1, count("/corefile"))
+
+	// Entries of removed list files are no longer blocked
+	listPath := filepath.Join(filepath.Dir(path), "local.txt")
+	assert.NoError(t, ioutil.WriteFile(listPath, []byte("0.0.0.0 local.example.com\n"), 0644))
+	policy = fmt.Sprintf("sources:\n  - url: %s/policy\n  - url: file://%s\n", server.URL, listPath)
+	assert.NoError(t, ioutil.WriteFile(path+".tmp", []byte(policy), 0644))
+	assert.NoError(t, os.Rename(path+".tmp", path))
+	assert.Eventually(t, func() bool {
+		return p.ShouldBlock("local.example.com")
+	}, 5*time.Second, 50*time.Millisecond)
+
+	policy = fmt.Sprintf("sources:\n  - url: %s/policy\n", server.URL)
+	assert.NoError(t, ioutil.WriteFile(path+".tmp", []byte(policy), 0644))
+	assert.NoError(t, os.Rename(path+".tmp", path))
+	assert.Eventually(t, func() bool {
+		return !p.ShouldBlock("local.example.com")
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.True(t, p.ShouldBlock("policy.example.com"))
+}
+
+func TestPolicyFile_Groups(t *testing.T) {
+	defer filet.CleanUp(t)
+
+	_, err := loadPolicy(writePolicy(t, "groups:\n  - name: kids\n"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "client groups are not supported")
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"context"
+
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+// Policies for requests received before the lists have been loaded
+const (
+	startupPolicyPassThrough = "pass-through"
+	startupPolicyServfail    = "servfail"
+	startupPolicyBlock       = "block"
+)
+
+// Ready implements the ready.Readiness interface. The plugin is ready once
+// all lists have been loaded for the first time.
+func (e *DNSAdBlock) Ready() bool {
+	e = e.active()
+	return e.updater == nil || e.updater.isLoaded()
+}
+
+// serveStartup answers requests received before the plugin is ready
+// according to the startup policy. It returns false if the request has to
+// be handled as usual.
+func (e *DNSAdBlock) serveStartup(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, state *request.Request, trimmedQname string) (int, bool) {
+	switch e.config.StartupPolicy {
+	case startupPolicyServfail:
+		return dns.RcodeServerFailure, true
+	case startupPolicyBlock:
+		if e.IsWhitelisted(trimmedQname) {
+			return 0, false
+		}
+		blockedRequestCountTotal.WithLabelValues(metrics.WithServer(ctx)).Inc()
+		blockedRequestCount.WithLabelValues(metrics.WithServer(ctx)).Inc()
+		e.onBlock(ctx, w, r, state, trimmedQname)
+		return dns.RcodeSuccess, true
+	}
+	return 0, false
+}
+
+func parseStartupPolicy(v string) (string, bool) {
+	switch v {
+	case startupPolicyPassThrough, startupPolicyServfail, startupPolicyBlock:
+		return v, true
+	}
+	return "", false
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReady(t *testing.T) {
+	p := initTestPlugin(t, getEmptyRuleset())
+	assert.True(t, p.Ready())
+
+	p.updater = &ListUpdater{Plugin: p, UpdateInterval: time.Hour, RetryCount: 1}
+	assert.False(t, p.Ready())
+
+	p.updater.Start()
+	defer p.updater.Stop()
+	assert.Eventually(t, p.Ready, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestStartupPolicy(t *testing.T) {
+	p := initTestPlugin(t, BuildRuleset([]string{"permitted.example.com"}, make([]string, 0)))
+	p.updater = &ListUpdater{Plugin: p}
+
+	serve := func(qname string) (int, *dns.Msg) {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		rcode, err := p.ServeDNS(context.TODO(), rec, test.Case{Qname: qname, Qtype: dns.TypeA}.Msg())
+		assert.NoError(t, err)
+		return rcode, rec.Msg
+	}
+
+	p.config.StartupPolicy = startupPolicyPassThrough
+	rcode, _ := serve("example.com")
+	assert.Equal(t, dns.RcodeNameError, rcode)
+
+	p.config.StartupPolicy = startupPolicyServfail
+	rcode, msg := serve("example.com")
+	assert.Equal(t, dns.RcodeServerFailure, rcode)
+	assert.Nil(t, msg)
+
+	p.config.StartupPolicy = startupPolicyBlock
+	rcode, msg = serve("example.com")
+	assert.Equal(t, dns.RcodeSuccess, rcode)
+	if assert.NotNil(t, msg) && assert.Len(t, msg.Answer, 1) {
+		assert.Equal(t, "10.1.33.7", msg.Answer[0].(*dns.A).A.String())
+	}
+	rcode, _ = serve("permitted.example.com")
+	assert.Equal(t, dns.RcodeNameError, rcode)
+
+	// Once the lists are loaded, the policy no longer applies
+	p.updater.loaded = 1
+	rcode, _ = serve("example.com")
+	assert.Equal(t, dns.RcodeNameError, rcode)
+}
+
+func TestSetup_StartupPolicy(t *testing.T) {
+	c := caddy.NewTestController("dns", "ads {\n startup-policy servfail\n}")
+	c.Next()
+	cfg, err := parsePluginConfiguration(c)
+	assert.NoError(t, err)
+	assert.Equal(t, startupPolicyServfail, cfg.StartupPolicy)
+
+	for _, v := range []string{
+		"ads {\n startup-policy\n}",
+		"ads {\n startup-policy fail-closed\n}",
+	} {
+		c = caddy.NewTestController("dns", v)
+		c.Next()
+		_, err = parsePluginConfiguration(c)
+		assert.Error(t, err, v)
+	}
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// rebindingNetworks contains the private, loopback and link-local networks
+// as well as 0.0.0.0/8, which browsers route to the local host. Public names
+// must not resolve to them if the rebinding protection is enabled.
+var rebindingNetworks = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+}
+
+var rebindingNetworkSet = func() *NetworkSet {
+	set := NewNetworkSet()
+	for _, v := range rebindingNetworks {
+		network, _ := parseNetwork(v)
+		set.Add(network)
+	}
+	return set
+}()
+
+// isRebinding checks if a public name resolves to an internal address. Names
+// within the zones allowed by the configuration may resolve to any address.
+func (e *DNSAdBlock) isRebinding(qname string, ip net.IP) bool {
+	if !e.config.EnableRebindingProtection || !rebindingNetworkSet.Contains(ip) {
+		return false
+	}
+	qname = dns.Fqdn(strings.ToLower(qname))
+	for _, zone := range e.config.RebindingAllowedZones {
+		if dns.IsSubDomain(zone, qname) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookup_RebindingProtection(t *testing.T) {
+	p := initTestPlugin(t, getEmptyRuleset())
+	p.config.EnableRebindingProtection = true
+	p.config.RebindingAllowedZones = []string{"lan.", "corp.example.com."}
+	p.config.TargetIP = net.ParseIP("127.0.0.1")
+	p.Next = upstreamHandler(map[string][]string{
+		"rebind.test.tld.":       {"rebind.test.tld. 3600 IN A 192.168.1.1"},
+		"rebind6.test.tld.":      {"rebind6.test.tld. 3600 IN AAAA fd00::1"},
+		"public.test.tld.":       {"public.test.tld. 3600 IN A 203.0.113.10"},
+		"mixed.test.tld.":        {"mixed.test.tld. 3600 IN A 203.0.113.11", "mixed.test.tld. 3600 IN A 10.0.0.1"},
+		"nas.lan.":               {"nas.lan. 3600 IN A 192.168.1.2"},
+		"git.corp.example.com.":  {"git.corp.example.com. 3600 IN A 10.0.0.2"},
+		"alias.test.tld.":        {"alias.test.tld. 3600 IN CNAME nas.lan.", "nas.lan. 3600 IN A 192.168.1.2"},
+		"localhost.test.tld.":    {"localhost.test.tld. 60 IN A 127.0.0.1"},
+		"zero.test.tld.":         {"zero.test.tld. 60 IN A 0.0.0.0"},
+		"link-local.test.tld.":   {"link-local.test.tld. 3600 IN A 169.254.169.254"},
+		"corp.example.com.test.": {"corp.example.com.test. 3600 IN A 10.0.0.3"},
+	})
+
+	// Internal addresses are removed, the sinkhole address is internal too
+	testCases := []test.Case{
+		{Qname: "rebind.test.tld", Qtype: dns.TypeA},
+		{Qname: "rebind6.test.tld", Qtype: dns.TypeAAAA},
+		{Qname: "localhost.test.tld", Qtype: dns.TypeA},
+		{Qname: "zero.test.tld", Qtype: dns.TypeA},
+		{Qname: "link-local.test.tld", Qtype: dns.TypeA},
+		{Qname: "corp.example.com.test", Qtype: dns.TypeA},
+		{Qname: "mixed.test.tld", Qtype: dns.TypeA, Answer: []dns.RR{test.A("mixed.test.tld. 3600 IN A 203.0.113.11")}},
+		{Qname: "alias.test.tld", Qtype: dns.TypeA, Answer: []dns.RR{test.CNAME("alias.test.tld. 3600 IN CNAME nas.lan.")}},
+		{Qname: "public.test.tld", Qtype: dns.TypeA, Answer: []dns.RR{test.A("public.test.tld. 3600 IN A 203.0.113.10")}},
+		{Qname: "nas.lan", Qtype: dns.TypeA, Answer: []dns.RR{test.A("nas.lan. 3600 IN A 192.168.1.2")}},
+		{Qname: "git.corp.example.com", Qtype: dns.TypeA, Answer: []dns.RR{test.A("git.corp.example.com. 3600 IN A 10.0.0.2")}},
+	}
+	resolveTestCases(testCases, p, context.TODO(), t)
+
+	for _, name := range []string{"rebind.test.tld", "localhost.test.tld", "zero.test.tld", "alias.test.tld"} {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		_, err := p.ServeDNS(context.TODO(), rec, test.Case{Qname: name, Qtype: dns.TypeA}.Msg())
+		assert.NoError(t, err)
+		for _, rr := range rec.Msg.Answer {
+			if a, ok := rr.(*dns.A); ok {
+				assert.False(t, rebindingNetworkSet.Contains(a.A), "%s resolves to %s", name, a.A)
+			}
+		}
+	}
+}
+
+func TestSetup_RebindingProtection(t *testing.T) {
+	c := caddy.NewTestController("dns", `ads {
+  rebind-protection lan Corp.Example.com
+}`)
+	c.Next()
+	cfg, err := parsePluginConfiguration(c)
+	assert.NoError(t, err)
+	assert.True(t, cfg.EnableRebindingProtection)
+	assert.Equal(t, []string{"lan.", "corp.example.com."}, cfg.RebindingAllowedZones)
+}
@@ -16,7 +16,10 @@
 
 package ads
 
-import "regexp"
+import (
+	"net"
+	"regexp"
+)
 
 type IRuleset interface {
 	IsBlacklisted(qn string) bool
@@ -24,10 +27,17 @@ type IRuleset interface {
 }
 
 type UpdateableRuleset struct {
-	Blacklist        map[string]bool
-	Whitelist        map[string]bool
-	BlacklistSources []string
-	WhitelistSources []string
+	Blacklist         map[string]bool
+	Whitelist         map[string]bool
+	BlacklistNetworks *NetworkSet
+	// Actions of rules with their own response, regex actions are keyed
+	// by the expression
+	BlacklistActions      map[string]*blockAction
+	BlacklistRegexActions map[string]*blockAction
+	// CnameCloakingTargets are matched including their subdomains
+	CnameCloakingTargets map[string]bool
+	BlacklistSources     []string
+	WhitelistSources     []string
 }
 
 func NewFileRuleSet(whitelist, blacklist []string) *UpdateableRuleset {
@@ -45,19 +55,34 @@ func (u *UpdateableRuleset) IsWhitelisted(qn string) bool {
 	return u.Whitelist[qn]
 }
 
+func (u *UpdateableRuleset) IsNetworkBlacklisted(ip net.IP) bool {
+	return u.BlacklistNetworks.Contains(ip)
+}
+
 type ConfiguredRuleSet struct {
-	Blacklist      map[string]bool
-	Whitelist      map[string]bool
-	WhitelistRegex []*regexp.Regexp
-	BlacklistRegex []*regexp.Regexp
+	Blacklist         map[string]bool
+	Whitelist         map[string]bool
+	WhitelistRegex    []*regexp.Regexp
+	BlacklistRegex    []*regexp.Regexp
+	BlacklistNetworks *NetworkSet
+	// Actions of rules with their own response, regex actions are keyed
+	// by the expression
+	BlacklistActions      map[string]*blockAction
+	BlacklistRegexActions map[string]*blockAction
+	// CnameCloakingTargets are matched including their subdomains
+	CnameCloakingTargets map[string]bool
 }
 
 func BuildRuleset(whitelist, blacklist []string) ConfiguredRuleSet {
 	r := ConfiguredRuleSet{
-		Blacklist:      make(map[string]bool),
-		Whitelist:      make(map[string]bool),
-		WhitelistRegex: make([]*regexp.Regexp, 0),
-		BlacklistRegex: make([]*regexp.Regexp, 0),
+		Blacklist:             make(map[string]bool),
+		Whitelist:             make(map[string]bool),
+		WhitelistRegex:        make([]*regexp.Regexp, 0),
+		BlacklistRegex:        make([]*regexp.Regexp, 0),
+		BlacklistNetworks:     NewNetworkSet(),
+		BlacklistActions:      make(map[string]*blockAction),
+		BlacklistRegexActions: make(map[string]*blockAction),
+		CnameCloakingTargets:  make(map[string]bool),
 	}
 
 	for _, v := range whitelist {
@@ -101,6 +126,14 @@ func (r *ConfiguredRuleSet) AddToBlacklist(qname string) {
 	r.Blacklist[qname] = true
 }
 
+func (r *ConfiguredRuleSet) AddNetworkToBlacklist(network *net.IPNet) {
+	r.BlacklistNetworks.Add(network)
+}
+
+func (r *ConfiguredRuleSet) AddCnameCloakingTarget(qname string) {
+	r.CnameCloakingTargets[qname] = true
+}
+
 func (r *ConfiguredRuleSet) IsWhitelisted(qname string) bool {
 	for _, v := range r.WhitelistRegex {
 		if v.MatchString(qname) {
@@ -119,3 +152,7 @@ func (r *ConfiguredRuleSet) IsBlacklisted(qname string) bool {
 	}
 	return r.Blacklist[qname]
 }
+
+func (r *ConfiguredRuleSet) IsNetworkBlacklisted(ip net.IP) bool {
+	return r.BlacklistNetworks.Contains(ip)
+}
@@ -17,8 +17,6 @@
 package ads
 
 import (
-	"time"
-
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
@@ -44,64 +42,86 @@ func setup(c *caddy.Controller) error {
 		return err
 	}
 
-	updater := &ListUpdater{
-		Enabled:         cfg.EnableAutoUpdate,
-		RetryCount:      cfg.ListRenewalRetryCount,
-		RetryDelay:      cfg.ListRenewalRetryInterval,
-		UpdateInterval:  cfg.HttpListRenewalInterval,
-		Plugin:          nil,
-		persistLists:    cfg.EnableListPersistence,
-		persistencePath: cfg.ListPersistencePath,
+	updater, err := newListUpdater(cfg)
+	if err != nil {
+		return err
 	}
 
-	c.OnStartup(func() error {
-		once.Do(func() {
+	adsPlugin := &DNSAdBlock{
+		config:  cfg,
+		updater: updater,
+	}
+	updater.Plugin = adsPlugin
+
+	// On a reload, the new instance starts before the current one is shut
+	// down. Lists are shared between both, so they are not fetched again.
+	if cfg.PolicyFile != "" {
+		// The watcher replaces the updater if the policy changes the lists
+		watcher := &policyWatcher{Plugin: adsPlugin}
+		c.OnStartup(func() error {
 			updater.Start()
+			return watcher.Start()
 		})
-		return nil
-	})
+		c.OnShutdown(func() error {
+			watcher.Stop()
+			return nil
+		})
+	} else {
+		c.OnStartup(func() error {
+			updater.Start()
+			return nil
+		})
+		c.OnShutdown(func() error {
+			updater.Stop()
+			return nil
+		})
+	}
 
-	bl := make(ListMap, 0)
-	wl := make(ListMap, 0)
+	var blockPageServer *BlockPageServer
+	if cfg.BlockPageServer != nil {
+		blockPageServer, err = newBlockPageServer(cfg.BlockPageServer)
+		if err != nil {
+			return plugin.Error("ads", err)
+		}
+		c.OnStartup(blockPageServer.Start)
+		// The listeners have to be released before the new instance starts
+		c.OnRestart(blockPageServer.Stop)
+		c.OnRestartFailed(blockPageServer.Start)
+		c.OnShutdown(blockPageServer.Stop)
+	}
 
 	ruleset, err := buildRulesetFromConfig(cfg)
 	if err != nil {
 		return err
 	}
+	adsPlugin.ConfiguredRuleSet = *ruleset
+	if blockPageServer != nil {
+		blockPageServer.Plugin = adsPlugin
+	}
 
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
-
-		adsPlugin := DNSAdBlock{
-			Next:              next,
-			ConfiguredRuleSet: *ruleset,
-			FileRuleSet:       *NewFileRuleSet(cfg.WhitelistFiles, cfg.BlacklistFiles),
-			blacklist:         bl,
-			whitelist:         wl,
-			config:            cfg,
-		}
-
-		updater.Plugin = &adsPlugin
-
-		if !cfg.EnableAutoUpdate {
-			adsPlugin.updater = updater
-		}
-
-		return &adsPlugin
+		adsPlugin.Next = next
+		return adsPlugin
 	})
 
 	return nil
 }
 
-func (u *ListUpdater) persistLoadedHttpLists() {
-	u.lastPersistenceUpdate = time.Now()
-	if u.Enabled {
-		persistedBlocklist := StoredListConfiguration{
-			UpdateTimestamp: int(time.Now().Unix()),
-			BlacklistURLs:   u.Plugin.config.BlacklistURLs,
-			WhitelistURLs:   u.Plugin.config.WhitelistURLs,
-			Blacklist:       u.Plugin.blacklist,
-			Whitelist:       u.Plugin.whitelist,
-		}
-		persistedBlocklist.Persist(u.persistencePath)
+func newListUpdater(cfg *adsPluginConfig) (*ListUpdater, error) {
+	fetcher, err := newListFetcher(cfg)
+	if err != nil {
+		return nil, plugin.Error("ads", err)
 	}
+
+	return &ListUpdater{
+		Enabled:         cfg.EnableAutoUpdate,
+		RetryCount:      cfg.ListRenewalRetryCount,
+		RetryDelay:      cfg.ListRenewalRetryInterval,
+		UpdateInterval:  cfg.HttpListRenewalInterval,
+		Fallback:        cfg.EnableFallbackList,
+		Plugin:          nil,
+		Fetcher:         fetcher,
+		persistLists:    cfg.EnableListPersistence,
+		persistencePath: cfg.ListPersistencePath,
+	}, nil
 }
@@ -17,13 +17,19 @@
 package ads
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"net"
+	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/plugin"
+	"github.com/miekg/dns"
 	"golang.org/x/net/idna"
 )
 
@@ -33,10 +39,22 @@ type adsPluginConfig struct {
 	BlacklistFiles      []string
 	WhitelistFiles      []string
 	BlacklistRules      []string
+	BlacklistNetworks   []*net.IPNet
 	WhitelistRules      []string
 	RegexBlacklistRules []string
 	RegexWhitelistRules []string
 
+	// Actions of `block` and `block-regex` rules defining their own response
+	BlacklistRuleActions      map[string]*blockAction
+	RegexBlacklistRuleActions map[string]*blockAction
+
+	NetworkBlacklistURLs  []string
+	NetworkBlacklistFiles []string
+
+	CnameCloakingURLs  []string
+	CnameCloakingFiles []string
+	CnameCloakingRules []string
+
 	TargetIP   net.IP
 	TargetIPv6 net.IP
 
@@ -45,97 +63,377 @@ type adsPluginConfig struct {
 	ListRenewalRetryCount    int
 	ListRenewalRetryInterval time.Duration
 
+	ListFetchWorkers int
+	ListFetchTimeout time.Duration
+	ListMaxSize      int64
+
 	ListPersistencePath string
 
+	HTTPClient  *httpClientConfig
+	ListSources map[string]*listSourceConfig
+
+	// Catalog entries and categories are resolved once the whole
+	// configuration, including the catalog overlay, has been parsed
+	CatalogPath         string
+	CatalogLists        []string
+	BlockedCategories   []string
+	PermittedCategories map[string]bool
+
 	EnableLogging         bool
 	EnableAutoUpdate      bool
 	EnableListPersistence bool
+	EnableFallbackList    bool
+
+	StartupPolicy string
+
+	DisabledInspections map[uint16]bool
+
+	EnableRebindingProtection bool
+	RebindingAllowedZones     []string
 
 	WriteNXDomain bool
+	BlockPageHost string
+
+	TargetTTL    uint32
+	BlockPageTTL uint32
+	NXDomainTTL  uint32
+
+	BlockPageServer *blockPageServerConfig
+
+	// PolicyFile is applied on top of the options of the Corefile, which
+	// are kept in policyBase
+	PolicyFile string
+	policyBase *adsPluginConfig
+	listSets   listSets
 }
 
 func parsePluginConfiguration(c *caddy.Controller) (*adsPluginConfig, error) {
 	config := defaultConfigWithoutRules
+	config.ListSources = make(map[string]*listSourceConfig)
+	config.DisabledInspections = make(map[uint16]bool)
+	config.BlacklistRuleActions = make(map[string]*blockAction)
+	config.RegexBlacklistRuleActions = make(map[string]*blockAction)
+	config.PermittedCategories = make(map[string]bool)
+	sets := listSetsOf(c)
+	if err := parseOptions(c, &config, sets, nil); err != nil {
+		return nil, err
+	}
+
+	if config.PolicyFile != "" {
+		// The policy is applied on top of the Corefile options whenever
+		// the file changes, so these are kept
+		config.policyBase = config.clone()
+		config.listSets = sets
+		if err := config.applyPolicyFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	if s := config.BlockPageServer; s != nil {
+		if len(s.HTTPSAddrs) > 0 && s.CACertFile == "" {
+			return nil, plugin.Error("ads", c.Err("Serving the block page using HTTPS requires a CA"))
+		}
+		if err := s.setDefaults(config.TargetIP, config.TargetIPv6); err != nil {
+			return nil, plugin.Error("ads", c.Err(err.Error()))
+		}
+	}
+
+	if err := config.resolve(); err != nil {
+		return nil, plugin.Error("ads", c.Err(err.Error()))
+	}
+	return &config, nil
+}
+
+// resolve validates the combination of the options and adds the lists of
+// the catalog.
+func (cfg *adsPluginConfig) resolve() error {
+	if cfg.WriteNXDomain && cfg.BlockPageHost != "" {
+		return errors.New("The nxdomain and block-page options cannot be combined")
+	}
+	for _, category := range cfg.BlockedCategories {
+		if cfg.PermittedCategories[category] {
+			return fmt.Errorf("The category %q is blocked and permitted at the same time", category)
+		}
+	}
+
+	for _, warning := range cfg.conflictingRules() {
+		log.Warning(warning)
+	}
+
+	if err := cfg.resolveCatalogLists(); err != nil {
+		return err
+	}
+	cfg.applyCategoryPolicy()
+	return nil
+}
+
+// clone copies the configuration, so options can be added to the copy
+// without modifying the original.
+func (cfg *adsPluginConfig) clone() *adsPluginConfig {
+	c := *cfg
+	c.BlacklistURLs = copyStrings(cfg.BlacklistURLs)
+	c.WhitelistURLs = copyStrings(cfg.WhitelistURLs)
+	c.BlacklistFiles = copyStrings(cfg.BlacklistFiles)
+	c.WhitelistFiles = copyStrings(cfg.WhitelistFiles)
+	c.BlacklistRules = copyStrings(cfg.BlacklistRules)
+	c.BlacklistNetworks = append([]*net.IPNet(nil), cfg.BlacklistNetworks...)
+	c.WhitelistRules = copyStrings(cfg.WhitelistRules)
+	c.RegexBlacklistRules = copyStrings(cfg.RegexBlacklistRules)
+	c.RegexWhitelistRules = copyStrings(cfg.RegexWhitelistRules)
+	c.NetworkBlacklistURLs = copyStrings(cfg.NetworkBlacklistURLs)
+	c.NetworkBlacklistFiles = copyStrings(cfg.NetworkBlacklistFiles)
+	c.CnameCloakingURLs = copyStrings(cfg.CnameCloakingURLs)
+	c.CnameCloakingFiles = copyStrings(cfg.CnameCloakingFiles)
+	c.CnameCloakingRules = copyStrings(cfg.CnameCloakingRules)
+	c.CatalogLists = copyStrings(cfg.CatalogLists)
+	c.BlockedCategories = copyStrings(cfg.BlockedCategories)
+	c.RebindingAllowedZones = copyStrings(cfg.RebindingAllowedZones)
+
+	c.BlacklistRuleActions = make(map[string]*blockAction)
+	for k, v := range cfg.BlacklistRuleActions {
+		c.BlacklistRuleActions[k] = v
+	}
+	c.RegexBlacklistRuleActions = make(map[string]*blockAction)
+	for k, v := range cfg.RegexBlacklistRuleActions {
+		c.RegexBlacklistRuleActions[k] = v
+	}
+	c.PermittedCategories = make(map[string]bool)
+	for k, v := range cfg.PermittedCategories {
+		c.PermittedCategories[k] = v
+	}
+	c.DisabledInspections = make(map[uint16]bool)
+	for k, v := range cfg.DisabledInspections {
+		c.DisabledInspections[k] = v
+	}
+	// Sources are modified when catalog entries are added
+	c.ListSources = make(map[string]*listSourceConfig)
+	for k, v := range cfg.ListSources {
+		source := *v
+		c.ListSources[k] = &source
+	}
+	return &c
+}
+
+func copyStrings(s []string) []string {
+	return append([]string(nil), s...)
+}
+
+// parseOptions parses the options of an ads block or of a list set. The
+// names of the list sets currently being applied are passed in using.
+func parseOptions(c *caddy.Controller, config *adsPluginConfig, sets listSets, using []string) error {
 	for c.NextBlock() {
 		value := c.Val()
 
 		switch value {
 		case "default-lists":
-			config.BlacklistURLs = append(config.BlacklistURLs, defaultBlacklists...)
+			config.CatalogLists = append(config.CatalogLists, defaultBlacklists...)
 		case "strict-default-lists":
-			config.BlacklistURLs = append(config.BlacklistURLs, strictDefaultBlacklists...)
-			config.WhitelistURLs = append(config.WhitelistURLs, strictDefaultWhitelists...)
+			config.CatalogLists = append(config.CatalogLists, strictDefaultBlacklists...)
+			config.CatalogLists = append(config.CatalogLists, strictDefaultWhitelists...)
 		case "unfiltered-strict-default-lists":
-			config.BlacklistURLs = append(config.BlacklistURLs, strictDefaultBlacklists...)
-		case "blacklist":
+			config.CatalogLists = append(config.CatalogLists, strictDefaultBlacklists...)
+		case "list":
+			names := c.RemainingArgs()
+			if len(names) == 0 {
+				return plugin.Error("ads", c.Err("No list name defined"))
+			}
+			config.CatalogLists = append(config.CatalogLists, names...)
+		case "list-catalog":
+			if config.CatalogPath != "" {
+				return plugin.Error("ads", c.Err("Only one list catalog can be defined"))
+			}
 			if !c.NextArg() {
-				return nil, plugin.Error("ads", c.Err("No URL found after list token"))
+				return plugin.Error("ads", c.Err("No path for the list catalog defined"))
+			}
+			config.CatalogPath = c.Val()
+		case "block-category":
+			categories, err := parseCategories(c)
+			if err != nil {
+				return plugin.Error("ads", err)
 			}
-			parsedUrl, err := url.Parse(c.Val())
+			config.BlockedCategories = append(config.BlockedCategories, categories...)
+		case "permit-category":
+			categories, err := parseCategories(c)
 			if err != nil {
-				return nil, plugin.Error("ads", c.Err(fmt.Sprintf("Invaild URL. Got error while parsing %s", err.Error())))
-			} else if parsedUrl.Scheme != "http" && parsedUrl.Scheme != "https" && parsedUrl.Scheme != "file" {
-				return nil, plugin.Error("ads", c.Err(fmt.Sprintf("Invaild URL. The scheme %s is not supported!", parsedUrl.Scheme)))
-			} else if parsedUrl.Scheme == "http" || parsedUrl.Scheme == "https" {
-				config.BlacklistURLs = append(config.BlacklistURLs, c.Val())
-			} else {
-				config.BlacklistFiles = append(config.BlacklistFiles, parsedUrl.Path)
+				return plugin.Error("ads", err)
+			}
+			for _, category := range categories {
+				config.PermittedCategories[category] = true
+			}
+		case "blacklist":
+			if err := parseListSource(c, config, listKindBlacklist, &config.BlacklistURLs, &config.BlacklistFiles); err != nil {
+				return plugin.Error("ads", err)
 			}
 		case "whitelist":
+			if err := parseListSource(c, config, listKindWhitelist, &config.WhitelistURLs, &config.WhitelistFiles); err != nil {
+				return plugin.Error("ads", err)
+			}
+		case "ip-blacklist":
+			if err := parseListSource(c, config, listKindNetworks, &config.NetworkBlacklistURLs, &config.NetworkBlacklistFiles); err != nil {
+				return plugin.Error("ads", err)
+			}
+		case "block-ip":
+			args := c.RemainingArgs()
+			if len(args) == 0 {
+				return plugin.Error("ads", c.Err("No network for IP blacklist (block-ip) entry defined"))
+			}
+			for _, v := range args {
+				network, err := parseNetwork(v)
+				if err != nil {
+					return plugin.Error("ads", c.Err(err.Error()))
+				}
+				config.BlacklistNetworks = append(config.BlacklistNetworks, network)
+			}
+		case "cname-cloaking":
+			config.CatalogLists = append(config.CatalogLists, defaultCnameCloakingLists...)
+		case "cname-cloaking-list":
+			if err := parseListSource(c, config, listKindCnameCloaking, &config.CnameCloakingURLs, &config.CnameCloakingFiles); err != nil {
+				return plugin.Error("ads", err)
+			}
+		case "cname-cloak":
 			if !c.NextArg() {
-				return nil, plugin.Error("ads", c.Err("No URL found after list token"))
+				return plugin.Error("ads", c.Err("No domain for CNAME cloaking (cname-cloak) entry defined"))
+			}
+			v := c.Val()
+			encoded, err := idna.ToASCII(v)
+			if err != nil {
+				return plugin.Error("ads", c.Err(fmt.Sprintf("Could not decode IDN of qname %q", v)))
+			}
+			config.CnameCloakingRules = append(config.CnameCloakingRules, encoded)
+		case "http":
+			if config.HTTPClient != nil {
+				return plugin.Error("ads", c.Err("Only one http block can be defined"))
 			}
-			parsedUrl, err := url.Parse(c.Val())
+			config.HTTPClient = &httpClientConfig{Headers: make(http.Header)}
+			err := parseBlock(c, func() error {
+				ok, err := parseHTTPClientOption(c, config.HTTPClient)
+				if err == nil && !ok {
+					return c.Errf("Unknown http option %q", c.Val())
+				}
+				return err
+			})
 			if err != nil {
-				return nil, plugin.Error("ads", c.Err(fmt.Sprintf("Invaild URL. Got error while parsing %s", err.Error())))
-			} else if parsedUrl.Scheme != "http" && parsedUrl.Scheme != "https" && parsedUrl.Scheme != "file" {
-				return nil, plugin.Error("ads", c.Err(fmt.Sprintf("Invaild URL. The scheme %s is not supported!", parsedUrl.Scheme)))
-			} else if parsedUrl.Scheme == "http" || parsedUrl.Scheme == "https" {
-				config.WhitelistURLs = append(config.WhitelistURLs, c.Val())
-			} else {
-				config.WhitelistFiles = append(config.WhitelistFiles, parsedUrl.Path)
+				return plugin.Error("ads", err)
 			}
 		case "target":
 			if !c.NextArg() {
-				return nil, plugin.Error("ads", c.Err("No target IP specified"))
+				return plugin.Error("ads", c.Err("No target IP specified"))
 			}
 			ip := net.ParseIP(c.Val())
 			if ip == nil {
-				return nil, plugin.Error("ads", c.Err("Invalid target IP specified"))
+				return plugin.Error("ads", c.Err("Invalid target IP specified"))
 			}
 			config.TargetIP = ip
 		case "target-ipv6":
 			if !c.NextArg() {
-				return nil, plugin.Error("ads", c.Err("No target IP specified"))
+				return plugin.Error("ads", c.Err("No target IP specified"))
 			}
 			ip := net.ParseIP(c.Val())
 			if ip == nil {
-				return nil, plugin.Error("ads", c.Err("Invalid target IP specified"))
+				return plugin.Error("ads", c.Err("Invalid target IP specified"))
 			}
 			config.TargetIPv6 = ip
+		case "startup-policy":
+			if !c.NextArg() {
+				return plugin.Error("ads", c.Err("No startup policy defined"))
+			}
+			policy, ok := parseStartupPolicy(c.Val())
+			if !ok {
+				return plugin.Error("ads", c.Errf("Unknown startup policy %q, expected pass-through, servfail or block", c.Val()))
+			}
+			config.StartupPolicy = policy
+		case "disable-fallback-list":
+			config.EnableFallbackList = false
 		case "disable-auto-update":
 			config.EnableAutoUpdate = false
 			break
 		case "auto-update-interval":
 			if !c.NextArg() {
-				return nil, plugin.Error("ads", c.Err("No update interval defined"))
+				return plugin.Error("ads", c.Err("No update interval defined"))
 			}
 			i, err := time.ParseDuration(c.Val())
 			if err != nil {
-				return nil, plugin.Error("ads", err)
+				return plugin.Error("ads", c.Errf("Invalid duration %q", c.Val()))
 			}
-			config.ListRenewalRetryInterval = i
+			if i <= 0 {
+				return plugin.Error("ads", c.Err("The update interval has to be positive"))
+			}
+			config.HttpListRenewalInterval = i
 			break
-			//TODO Add Options for Failure Retry interval and Failure retry count
+		case "file-poll-interval":
+			if !c.NextArg() {
+				return plugin.Error("ads", c.Err("No poll interval defined"))
+			}
+			i, err := time.ParseDuration(c.Val())
+			if err != nil {
+				return plugin.Error("ads", c.Errf("Invalid duration %q", c.Val()))
+			}
+			if i <= 0 {
+				return plugin.Error("ads", c.Err("The poll interval has to be positive"))
+			}
+			config.FileListRenewalInterval = i
+		case "retry-count":
+			if !c.NextArg() {
+				return plugin.Error("ads", c.Err("No retry count defined"))
+			}
+			n, err := strconv.Atoi(c.Val())
+			if err != nil || n < 1 {
+				return plugin.Error("ads", c.Err("The retry count has to be a positive number"))
+			}
+			config.ListRenewalRetryCount = n
+		case "retry-interval":
+			if !c.NextArg() {
+				return plugin.Error("ads", c.Err("No retry interval defined"))
+			}
+			i, err := time.ParseDuration(c.Val())
+			if err != nil {
+				return plugin.Error("ads", c.Errf("Invalid duration %q", c.Val()))
+			}
+			if i <= 0 {
+				return plugin.Error("ads", c.Err("The retry interval has to be positive"))
+			}
+			config.ListRenewalRetryInterval = i
+		case "fetch-workers":
+			if !c.NextArg() {
+				return plugin.Error("ads", c.Err("No worker count defined"))
+			}
+			n, err := strconv.Atoi(c.Val())
+			if err != nil || n < 1 {
+				return plugin.Error("ads", c.Err("The worker count has to be a positive number"))
+			}
+			config.ListFetchWorkers = n
+		case "fetch-timeout":
+			if !c.NextArg() {
+				return plugin.Error("ads", c.Err("No fetch timeout defined"))
+			}
+			d, err := time.ParseDuration(c.Val())
+			if err != nil {
+				return plugin.Error("ads", c.Errf("Invalid duration %q", c.Val()))
+			}
+			if d < 0 {
+				return plugin.Error("ads", c.Err("The fetch timeout must not be negative"))
+			}
+			config.ListFetchTimeout = d
+		case "max-list-size":
+			if !c.NextArg() {
+				return plugin.Error("ads", c.Err("No maximum list size defined"))
+			}
+			size, err := parseByteSize(c.Val())
+			if err != nil {
+				return plugin.Error("ads", c.Err(err.Error()))
+			}
+			config.ListMaxSize = size
 		case "list-store":
 			if !c.NextArg() {
-				return nil, plugin.Error("ads", c.Err("No filepath for blocklist persistency defined"))
+				return plugin.Error("ads", c.Err("No filepath for blocklist persistency defined"))
 			}
 			if config.EnableListPersistence {
-				return nil, plugin.Error("ads", c.Err("Only one filepath for blocklist persistency can be defined"))
+				return plugin.Error("ads", c.Err("Only one filepath for blocklist persistency can be defined"))
 			}
 			path := c.Val()
-			//TODO implement check if path is valid
+			if err := checkWritable(path); err != nil {
+				return plugin.Error("ads", c.Errf("The list store %q is not writable: %s", path, err.Error()))
+			}
 			config.EnableListPersistence = true
 			config.ListPersistencePath = path
 			break
@@ -143,56 +441,269 @@ func parsePluginConfiguration(c *caddy.Controller) (*adsPluginConfig, error) {
 			config.EnableLogging = true
 		case "block":
 			if !c.NextArg() {
-				return nil, plugin.Error("ads", c.Err("No name for blacklist (block) entry defined"))
+				return plugin.Error("ads", c.Err("No name for blacklist (block) entry defined"))
 			}
 			v := c.Val()
 			encoded, err := idna.ToASCII(v)
 			if err != nil {
-				return nil, plugin.Error("ads", c.Err(fmt.Sprintf("Could not decode IDN of qname %q", v)))
+				return plugin.Error("ads", c.Err(fmt.Sprintf("Could not decode IDN of qname %q", v)))
 			}
 			config.BlacklistRules = append(config.BlacklistRules, encoded)
+			action, err := parseRuleAction(c)
+			if err != nil {
+				return plugin.Error("ads", err)
+			} else if action != nil {
+				config.BlacklistRuleActions[encoded] = action
+			}
 			break
 		case "block-regex":
 			if !c.NextArg() {
-				return nil, plugin.Error("ads", c.Err("No name for blacklist regex (block-regex) entry defined"))
+				return plugin.Error("ads", c.Err("No name for blacklist regex (block-regex) entry defined"))
+			}
+			v := c.Val()
+			config.RegexBlacklistRules = append(config.RegexBlacklistRules, v)
+			action, err := parseRuleAction(c)
+			if err != nil {
+				return plugin.Error("ads", err)
+			} else if action != nil {
+				config.RegexBlacklistRuleActions[v] = action
 			}
-			config.RegexBlacklistRules = append(config.RegexBlacklistRules, c.Val())
 			break
 		case "permit":
 			if !c.NextArg() {
-				return nil, plugin.Error("ads", c.Err("No name for whitelist (permit) entry defined"))
+				return plugin.Error("ads", c.Err("No name for whitelist (permit) entry defined"))
 			}
 			v := c.Val()
 			encoded, err := idna.ToASCII(v)
 			if err != nil {
-				return nil, plugin.Error("ads", c.Err(fmt.Sprintf("Could not decode IDN of qname %q", v)))
+				return plugin.Error("ads", c.Err(fmt.Sprintf("Could not decode IDN of qname %q", v)))
 			}
 			config.WhitelistRules = append(config.WhitelistRules, encoded)
 			break
 		case "permit-regex":
 			if !c.NextArg() {
-				return nil, plugin.Error("ads", c.Err("No name for whitelist regex (permit-regex) entry defined"))
+				return plugin.Error("ads", c.Err("No name for whitelist regex (permit-regex) entry defined"))
 			}
 			config.RegexWhitelistRules = append(config.RegexWhitelistRules, c.Val())
 			break
+		case "inspect":
+			err := parseBlock(c, func() error {
+				return parseInspectOption(c, config)
+			})
+			if err != nil {
+				return plugin.Error("ads", err)
+			}
+		case "rebind-protection":
+			config.EnableRebindingProtection = true
+			for _, v := range c.RemainingArgs() {
+				if _, ok := dns.IsDomainName(v); !ok {
+					return plugin.Error("ads", c.Errf("Invalid zone %q for rebind-protection", v))
+				}
+				config.RebindingAllowedZones = append(config.RebindingAllowedZones, dns.Fqdn(strings.ToLower(v)))
+			}
+		case "block-page":
+			if !c.NextArg() {
+				return plugin.Error("ads", c.Err("No host for the block page defined"))
+			}
+			if _, ok := dns.IsDomainName(c.Val()); !ok {
+				return plugin.Error("ads", c.Errf("Invalid block page host %q", c.Val()))
+			}
+			config.BlockPageHost = dns.Fqdn(strings.ToLower(c.Val()))
+		case "block-page-server":
+			if config.BlockPageServer != nil {
+				return plugin.Error("ads", c.Err("Only one block-page-server block can be defined"))
+			}
+			config.BlockPageServer = &blockPageServerConfig{}
+			err := parseBlock(c, func() error {
+				return parseBlockPageServerOption(c, config.BlockPageServer)
+			})
+			if err != nil {
+				return plugin.Error("ads", err)
+			}
+		case "ttl":
+			if err := parseTTLs(c, config); err != nil {
+				return plugin.Error("ads", err)
+			}
 		case "nxdomain":
 			config.WriteNXDomain = true
 			break
+		case "policy-file":
+			if config.PolicyFile != "" {
+				return plugin.Error("ads", c.Err("Only one policy file can be defined"))
+			}
+			if len(using) > 0 {
+				return plugin.Error("ads", c.Err("The policy file cannot be defined within a list set"))
+			}
+			if !c.NextArg() {
+				return plugin.Error("ads", c.Err("No path for the policy file defined"))
+			}
+			config.PolicyFile = c.Val()
+		case "listset":
+			if len(using) > 0 {
+				return plugin.Error("ads", c.Err("List sets cannot be defined within a list set"))
+			}
+			if err := sets.define(c); err != nil {
+				return plugin.Error("ads", err)
+			}
+		case "use":
+			names := c.RemainingArgs()
+			if len(names) == 0 {
+				return plugin.Error("ads", c.Err("No list set to use defined"))
+			}
+			for _, name := range names {
+				if err := sets.apply(c, config, name, using); err != nil {
+					return err
+				}
+			}
 		case "}":
 			break
 		case "{":
 			break
+		default:
+			if suggestion := suggestOption(value); suggestion != "" {
+				return plugin.Error("ads", c.Errf("Unknown option %q, did you mean %q?", value, suggestion))
+			}
+			return plugin.Error("ads", c.Errf("Unknown option %q", value))
+		}
+
+		if c.NextArg() {
+			return plugin.Error("ads", c.Errf("Unexpected argument %q for %s", c.Val(), value))
 		}
 	}
+	return nil
+}
 
-	if len(config.BlacklistURLs) == 0 {
-		config.BlacklistURLs = defaultBlacklists
+func parseListSource(c *caddy.Controller, config *adsPluginConfig, kind listKind, urls, files *[]string) error {
+	if !c.NextArg() {
+		return c.Err("No URL found after list token")
 	}
-	return &config, nil
+	listUrl := c.Val()
+	parsedUrl, err := url.Parse(listUrl)
+	if err != nil {
+		return c.Err(fmt.Sprintf("Invaild URL. Got error while parsing %s", err.Error()))
+	} else if parsedUrl.Scheme != "http" && parsedUrl.Scheme != "https" && parsedUrl.Scheme != "file" {
+		return c.Err(fmt.Sprintf("Invaild URL. The scheme %s is not supported!", parsedUrl.Scheme))
+	}
+
+	isHTTP := parsedUrl.Scheme == "http" || parsedUrl.Scheme == "https"
+	if isHTTP {
+		*urls = append(*urls, listUrl)
+	} else {
+		*files = append(*files, parsedUrl.Path)
+	}
+
+	// The order of the sources decides which action is used for names
+	// contained in multiple lists
+	source := &listSourceConfig{URL: listUrl, Kind: kind, Order: len(config.ListSources)}
+	err = parseBlock(c, func() error {
+		return parseListSourceOption(c, source, isHTTP)
+	})
+	if err != nil {
+		return err
+	}
+	if err := source.validate(c); err != nil {
+		return err
+	}
+	if !isHTTP && isFileSourceCollection(parsedUrl.Path) && (source.Verification != nil || source.ArchiveMember != "") {
+		return c.Errf("The list %q loads multiple files, verification and archive options are not supported", listUrl)
+	}
+
+	if isHTTP {
+		config.ListSources[listUrl] = source
+	} else {
+		config.ListSources[parsedUrl.Path] = source
+	}
+	return nil
+}
+
+// parseTTLs parses either a TTL used by all response modes or a block
+// defining the TTL of every mode.
+func parseTTLs(c *caddy.Controller, config *adsPluginConfig) error {
+	args := c.RemainingArgs()
+	if len(args) > 1 {
+		return c.Err("The TTL has to be defined as 'ttl <DURATION>' or in a block")
+	}
+	if len(args) == 1 {
+		ttl, err := parseTTL(c, args[0])
+		if err != nil {
+			return err
+		}
+		config.TargetTTL, config.BlockPageTTL, config.NXDomainTTL = ttl, ttl, ttl
+		return nil
+	}
+
+	parsed := false
+	err := parseBlock(c, func() error {
+		var target *uint32
+		switch c.Val() {
+		case "target":
+			target = &config.TargetTTL
+		case "block-page":
+			target = &config.BlockPageTTL
+		case "nxdomain":
+			target = &config.NXDomainTTL
+		default:
+			return c.Errf("Unknown response mode %q", c.Val())
+		}
+		if !c.NextArg() {
+			return c.Errf("No TTL for %s responses defined", c.Val())
+		}
+		ttl, err := parseTTL(c, c.Val())
+		if err != nil {
+			return err
+		}
+		*target = ttl
+		parsed = true
+		return nil
+	})
+	if err == nil && !parsed {
+		return c.Err("No TTL defined")
+	}
+	return err
+}
+
+func parseTTL(c *caddy.Controller, v string) (uint32, error) {
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 || d > math.MaxInt32*time.Second || d%time.Second != 0 {
+		return 0, c.Errf("Invalid TTL %q, expected a duration in whole seconds, e.g. 30s", v)
+	}
+	return uint32(d / time.Second), nil
+}
+
+// parseBlock parses an optional block opened on the current line, calling
+// handle for every line within the block.
+func parseBlock(c *caddy.Controller, handle func() error) error {
+	if !c.NextArg() {
+		return nil
+	}
+	if c.Val() != "{" {
+		return c.Errf("Unexpected argument %q", c.Val())
+	}
+	for c.Next() {
+		if c.Val() == "}" {
+			return nil
+		}
+		if err := handle(); err != nil {
+			return err
+		}
+	}
+	return c.EOFErr()
 }
 
 func buildRulesetFromConfig(cfg *adsPluginConfig) (*ConfiguredRuleSet, error) {
 	ruleset := BuildRuleset(cfg.WhitelistRules, cfg.BlacklistRules)
+	for _, v := range cfg.BlacklistNetworks {
+		ruleset.AddNetworkToBlacklist(v)
+	}
+	for _, v := range cfg.CnameCloakingRules {
+		ruleset.AddCnameCloakingTarget(v)
+	}
+	for k, v := range cfg.BlacklistRuleActions {
+		ruleset.BlacklistActions[k] = v
+	}
+	for k, v := range cfg.RegexBlacklistRuleActions {
+		ruleset.BlacklistRegexActions[k] = v
+	}
 
 	for _, v := range cfg.RegexWhitelistRules {
 		if err := ruleset.AddRegexToWhitelist(v); err != nil {
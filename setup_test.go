@@ -17,11 +17,21 @@
 package ads
 
 import (
+	"encoding/json"
 	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net"
 	"net/http/httptest"
+	"path/filepath"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/Flaque/filet"
 	"github.com/coredns/caddy"
+	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -63,6 +73,32 @@ const invalid_Interval_InvalidDuration_Corefile = `ads {
   auto-update-interval not-a-parsable-duration-string
 }`
 
+const valid_Fetch_Corefile = `ads {
+  fetch-workers 8
+  fetch-timeout 30s
+  max-list-size 16MB
+}`
+const invalid_Fetch_Workers_Corefile = `ads {
+  fetch-workers 0
+}`
+const invalid_Fetch_Timeout_Corefile = `ads {
+  fetch-timeout forever
+}`
+const invalid_Fetch_Size_Corefile = `ads {
+  max-list-size 12XB
+}`
+const overflowing_Fetch_Size_Corefile = `ads {
+  max-list-size 9000000000GB
+}`
+
+const valid_Network_Corefile = `ads {
+  block-ip 192.0.2.0/24 2001:db8::1
+  ip-blacklist file:///etc/coredns/bad-networks.txt
+}`
+const invalid_Network_Corefile = `ads {
+  block-ip 192.0.2.0/33
+}`
+
 const valid_Whitelist_Single = `ads {
   block test.com
 }`
@@ -174,6 +210,206 @@ func TestSetup_InvalidInterval(t *testing.T) {
 	assert.Error(t, setup(c))
 }
 
+func TestSetup_UpdateOptions(t *testing.T) {
+	c := caddy.NewTestController("dns", `ads {
+  auto-update-interval 12h
+  retry-count 3
+  retry-interval 30s
+  blacklist https://lists.local/list.txt {
+    update-interval 1h
+    retry-count 10
+    retry-interval 5s
+  }
+}`)
+	c.Next()
+	cfg, err := parsePluginConfiguration(c)
+	assert.NoError(t, err)
+	assert.Equal(t, 12*time.Hour, cfg.HttpListRenewalInterval)
+	assert.Equal(t, 3, cfg.ListRenewalRetryCount)
+	assert.Equal(t, 30*time.Second, cfg.ListRenewalRetryInterval)
+
+	source := cfg.ListSources["https://lists.local/list.txt"]
+	assert.Equal(t, time.Hour, source.UpdateInterval)
+	assert.Equal(t, 10, source.RetryCount)
+	assert.Equal(t, 5*time.Second, source.RetryInterval)
+
+	for _, v := range []string{
+		"ads {\n auto-update-interval -5m\n}",
+		"ads {\n retry-count 0\n}",
+		"ads {\n retry-interval soon\n}",
+		"ads {\n blacklist file:///etc/coredns/list.txt {\n update-interval 1h\n }\n}",
+	} {
+		c := caddy.NewTestController("dns", v)
+		c.Next()
+		_, err := parsePluginConfiguration(c)
+		assert.Error(t, err)
+	}
+}
+
+func TestSetup_ValidFetchOptions(t *testing.T) {
+	s := updateDefaultBlocklists(t)
+	defer s.Close()
+
+	c := caddy.NewTestController("dns", valid_Fetch_Corefile)
+	c.Next()
+	cfg, err := parsePluginConfiguration(c)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, cfg.ListFetchWorkers)
+	assert.Equal(t, 30*time.Second, cfg.ListFetchTimeout)
+	assert.Equal(t, int64(16<<20), cfg.ListMaxSize)
+}
+
+func TestSetup_InvalidFetchOptions(t *testing.T) {
+	s := updateDefaultBlocklists(t)
+	defer s.Close()
+
+	cfs := []string{
+		invalid_Fetch_Workers_Corefile,
+		invalid_Fetch_Timeout_Corefile,
+		invalid_Fetch_Size_Corefile,
+		overflowing_Fetch_Size_Corefile,
+	}
+
+	for _, v := range cfs {
+		c := caddy.NewTestController("dns", v)
+		assert.Error(t, setup(c))
+	}
+}
+
+func TestSetup_Networks(t *testing.T) {
+	s := updateDefaultBlocklists(t)
+	defer s.Close()
+
+	c := caddy.NewTestController("dns", valid_Network_Corefile)
+	c.Next()
+	cfg, err := parsePluginConfiguration(c)
+	assert.NoError(t, err)
+	assert.Len(t, cfg.BlacklistNetworks, 2)
+	assert.Equal(t, []string{"/etc/coredns/bad-networks.txt"}, cfg.NetworkBlacklistFiles)
+
+	ruleset, err := buildRulesetFromConfig(cfg)
+	assert.NoError(t, err)
+	assert.True(t, ruleset.IsNetworkBlacklisted(net.ParseIP("192.0.2.42")))
+	assert.True(t, ruleset.IsNetworkBlacklisted(net.ParseIP("2001:db8::1")))
+	assert.False(t, ruleset.IsNetworkBlacklisted(net.ParseIP("2001:db8::2")))
+
+	c = caddy.NewTestController("dns", invalid_Network_Corefile)
+	assert.Error(t, setup(c))
+}
+
+func TestSetup_Inspection(t *testing.T) {
+	c := caddy.NewTestController("dns", `ads {
+  inspect {
+    mx off
+    SRV off
+    srv on
+  }
+}`)
+	c.Next()
+	cfg, err := parsePluginConfiguration(c)
+	assert.NoError(t, err)
+	assert.Equal(t, map[uint16]bool{dns.TypeMX: true}, cfg.DisabledInspections)
+	assert.Empty(t, defaultConfigWithoutRules.DisabledInspections)
+
+	for _, v := range []string{"ads {\n inspect {\n txt off\n }\n}", "ads {\n inspect {\n mx maybe\n }\n}"} {
+		c = caddy.NewTestController("dns", v)
+		c.Next()
+		_, err = parsePluginConfiguration(c)
+		assert.Error(t, err)
+	}
+}
+
+func TestSetup_BlockPage(t *testing.T) {
+	c := caddy.NewTestController("dns", "ads {\n block-page Blocked.Corp.Internal\n}")
+	c.Next()
+	cfg, err := parsePluginConfiguration(c)
+	assert.NoError(t, err)
+	assert.Equal(t, "blocked.corp.internal.", cfg.BlockPageHost)
+
+	c = caddy.NewTestController("dns", "ads {\n block-page blocked.corp.internal\n nxdomain\n}")
+	c.Next()
+	_, err = parsePluginConfiguration(c)
+	assert.Error(t, err)
+}
+
+func TestSetup_TTL(t *testing.T) {
+	c := caddy.NewTestController("dns", "ads {\n ttl 30s\n}")
+	c.Next()
+	cfg, err := parsePluginConfiguration(c)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{30, 30, 30}, []uint32{cfg.TargetTTL, cfg.BlockPageTTL, cfg.NXDomainTTL})
+
+	c = caddy.NewTestController("dns", "ads {\n ttl {\n target 1m\n nxdomain 0s\n }\n}")
+	c.Next()
+	cfg, err = parsePluginConfiguration(c)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{60, defaultBlockTTL, 0}, []uint32{cfg.TargetTTL, cfg.BlockPageTTL, cfg.NXDomainTTL})
+
+	for _, v := range []string{
+		"ads {\n ttl\n}",
+		"ads {\n ttl 1.5s\n}",
+		"ads {\n ttl -1s\n}",
+		"ads {\n ttl {\n sinkhole 1m\n }\n}",
+		"ads {\n ttl {\n target\n }\n}",
+	} {
+		c = caddy.NewTestController("dns", v)
+		c.Next()
+		_, err = parsePluginConfiguration(c)
+		assert.Error(t, err, v)
+	}
+}
+
+func TestSetup_BlockAction(t *testing.T) {
+	c := caddy.NewTestController("dns", `ads {
+ blacklist https://example.com/malware.txt {
+  action nxdomain
+ }
+ blacklist https://example.com/ads.txt {
+  action target 0.0.0.0 ::
+ }
+ blacklist file:///etc/lists/phishing {
+  action block-page Warning.Example.com
+ }
+ block tracker.example.com {
+  action target 10.0.0.1
+ }
+ block-regex ^ads\. {
+  action nxdomain
+ }
+}`)
+	c.Next()
+	cfg, err := parsePluginConfiguration(c)
+	assert.NoError(t, err)
+
+	malware := cfg.ListSources["https://example.com/malware.txt"]
+	assert.Equal(t, &blockAction{Mode: responseModeNXDomain}, malware.Action)
+	ads := cfg.ListSources["https://example.com/ads.txt"]
+	assert.Equal(t, &blockAction{Mode: responseModeTarget, TargetIP: net.ParseIP("0.0.0.0"), TargetIPv6: net.ParseIP("::")}, ads.Action)
+	phishing := cfg.ListSources["/etc/lists/phishing"]
+	assert.Equal(t, &blockAction{Mode: responseModeBlockPage, BlockPageHost: "warning.example.com."}, phishing.Action)
+	assert.True(t, malware.Order < ads.Order && ads.Order < phishing.Order)
+
+	assert.Equal(t, net.ParseIP("10.0.0.1"), cfg.BlacklistRuleActions["tracker.example.com"].TargetIP)
+	assert.Equal(t, responseModeNXDomain, cfg.RegexBlacklistRuleActions[`^ads\.`].Mode)
+
+	for _, v := range []string{
+		"ads {\n blacklist https://example.com/list.txt {\n action\n }\n}",
+		"ads {\n blacklist https://example.com/list.txt {\n action sinkhole\n }\n}",
+		"ads {\n blacklist https://example.com/list.txt {\n action target ::\n }\n}",
+		"ads {\n blacklist https://example.com/list.txt {\n action target 0.0.0.0 0.0.0.0\n }\n}",
+		"ads {\n blacklist https://example.com/list.txt {\n action nxdomain now\n }\n}",
+		"ads {\n blacklist https://example.com/list.txt {\n action block-page\n }\n}",
+		"ads {\n whitelist https://example.com/list.txt {\n action nxdomain\n }\n}",
+		"ads {\n block example.com {\n permit\n }\n}",
+		"ads {\n block example.com nxdomain\n}",
+	} {
+		c = caddy.NewTestController("dns", v)
+		c.Next()
+		_, err = parsePluginConfiguration(c)
+		assert.Error(t, err, v)
+	}
+}
+
 func TestSetup_ValidTarget(t *testing.T) {
 	s := updateDefaultBlocklists(t)
 	defer s.Close()
@@ -288,7 +524,170 @@ func TestSetup_InvalidRegexpWhiteAndBlacklist(t *testing.T) {
 func updateDefaultBlocklists(t *testing.T) *httptest.Server {
 	srv := initTestServer(t)
 
-	defaultBlacklists = []string{fmt.Sprintf("%s/my-test-list.txt", srv.URL)}
+	catalog, err := parseListCatalog(builtinCatalogData)
+	assert.NoError(t, err)
+	catalog.Lists = append(catalog.Lists, &catalogEntry{
+		Name:       "test-list",
+		URL:        fmt.Sprintf("%s/my-test-list.txt", srv.URL),
+		Format:     listFormatDomains,
+		Categories: []string{categoryAds},
+	})
+	data, err := json.Marshal(catalog)
+	assert.NoError(t, err)
+
+	originalCatalog, originalDefaults := builtinCatalogData, defaultBlacklists
+	builtinCatalogData, defaultBlacklists = data, []string{"test-list"}
+	t.Cleanup(func() {
+		builtinCatalogData, defaultBlacklists = originalCatalog, originalDefaults
+	})
 
 	return srv
 }
+
+func TestSetup_UnknownOption(t *testing.T) {
+	c := caddy.NewTestController("dns", "ads {\n  blacklists https://lists.local/list.txt\n}")
+	c.Next()
+	_, err := parsePluginConfiguration(c)
+	assert.EqualError(t, err, `plugin/ads: Testfile:2 - Error during parsing: Unknown option "blacklists", did you mean "blacklist"?`)
+
+	c = caddy.NewTestController("dns", "ads {\n  strict-defualt-lists\n}")
+	c.Next()
+	_, err = parsePluginConfiguration(c)
+	assert.Contains(t, err.Error(), `did you mean "strict-default-lists"?`)
+
+	c = caddy.NewTestController("dns", "ads {\n  enable-everything\n}")
+	c.Next()
+	_, err = parsePluginConfiguration(c)
+	assert.EqualError(t, err, `plugin/ads: Testfile:2 - Error during parsing: Unknown option "enable-everything"`)
+
+	// Every option is known
+	for _, option := range options {
+		c := caddy.NewTestController("dns", fmt.Sprintf("ads {\n  %s\n}", option))
+		c.Next()
+		if _, err := parsePluginConfiguration(c); err != nil {
+			assert.NotContains(t, err.Error(), "Unknown option", option)
+		}
+	}
+}
+
+func TestSetup_OptionsComplete(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "setup_parse.go", nil, 0)
+	assert.NoError(t, err)
+
+	// Every option handled by parseOptions has to be suggested as well
+	parsed := 0
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Name.Name != "parseOptions" {
+			continue
+		}
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			s, ok := n.(*ast.SwitchStmt)
+			if !ok {
+				return true
+			}
+			if tag, ok := s.Tag.(*ast.Ident); !ok || tag.Name != "value" {
+				return true
+			}
+			for _, stmt := range s.Body.List {
+				for _, expr := range stmt.(*ast.CaseClause).List {
+					lit, ok := expr.(*ast.BasicLit)
+					if !ok || lit.Kind != token.STRING {
+						continue
+					}
+					option, err := strconv.Unquote(lit.Value)
+					assert.NoError(t, err)
+					// The braces of a block are no options
+					if option == "{" || option == "}" {
+						continue
+					}
+					assert.Contains(t, options, option, "%q is missing in the list of options", option)
+					parsed++
+				}
+			}
+			return false
+		})
+	}
+	assert.Equal(t, len(options), parsed)
+}
+
+func TestSetup_UnexpectedArguments(t *testing.T) {
+	for _, v := range []string{
+		"ads {\n  log verbose\n}",
+		"ads {\n  default-lists now\n}",
+		"ads {\n  nxdomain please\n}",
+		"ads {\n  target 10.0.0.1 10.0.0.2\n}",
+		"ads {\n  permit a.example.com b.example.com\n}",
+		"ads {\n  block a.example.com b.example.com\n}",
+		"ads {\n  auto-update-interval 1h 2h\n}",
+		"ads {\n  list-catalog /etc/coredns/catalog.json /tmp/catalog.json\n}",
+		"ads {\n  blacklist https://lists.local/list.txt https://lists.local/other.txt\n}",
+	} {
+		c := caddy.NewTestController("dns", v)
+		c.Next()
+		_, err := parsePluginConfiguration(c)
+		assert.Error(t, err, v)
+	}
+}
+
+func TestSetup_InvalidValues(t *testing.T) {
+	for _, v := range []string{
+		"ads {\n  auto-update-interval -5m\n}",
+		"ads {\n  auto-update-interval 0s\n}",
+		"ads {\n  file-poll-interval -1s\n}",
+		"ads {\n  retry-interval -1s\n}",
+		"ads {\n  retry-count -1\n}",
+		"ads {\n  fetch-workers -2\n}",
+		"ads {\n  fetch-timeout -1s\n}",
+		"ads {\n  max-list-size -1MB\n}",
+		"ads {\n  ttl -30s\n}",
+	} {
+		c := caddy.NewTestController("dns", v)
+		c.Next()
+		_, err := parsePluginConfiguration(c)
+		assert.Error(t, err, v)
+	}
+}
+
+func TestSetup_ListStorePath(t *testing.T) {
+	dir := filet.TmpDir(t, "")
+	defer filet.CleanUp(t)
+
+	for _, v := range []string{
+		filepath.Join(dir, "missing", "lists.json"),
+		dir,
+	} {
+		c := caddy.NewTestController("dns", fmt.Sprintf("ads {\n  list-store %s\n}", v))
+		c.Next()
+		_, err := parsePluginConfiguration(c)
+		assert.Error(t, err, v)
+	}
+
+	path := filepath.Join(dir, "lists.json")
+	c := caddy.NewTestController("dns", fmt.Sprintf("ads {\n  list-store %s\n  disable-auto-update\n  blacklist https://lists.local/list.txt\n}", path))
+	c.Next()
+	cfg, err := parsePluginConfiguration(c)
+	assert.NoError(t, err)
+	assert.Equal(t, path, cfg.ListPersistencePath)
+	// The check does not create the store
+	assert.NoFileExists(t, path)
+}
+
+func TestSetup_ConflictingRules(t *testing.T) {
+	c := caddy.NewTestController("dns", `ads {
+  block a.example.com
+  block a.example.com
+  block b.example.com
+  permit b.example.com
+  block-regex ^ads\.
+  permit-regex ^ads\.
+}`)
+	c.Next()
+	cfg, err := parsePluginConfiguration(c)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"The rule 'block a.example.com' is defined multiple times",
+		"The rule 'block b.example.com' is also permitted, permitting takes precedence",
+		`The rule 'block-regex ^ads\.' is also permitted, permitting takes precedence`,
+	}, cfg.conflictingRules())
+}
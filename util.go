@@ -21,37 +21,52 @@ import (
 	gz "compress/gzip"
 	"fmt"
 	"github.com/miekg/dns"
+	"io"
 	"io/ioutil"
+	"math"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 )
 
-func a(zone string, ips []net.IP) []dns.RR {
+func a(zone string, ips []net.IP, ttl uint32) []dns.RR {
 	var answers []dns.RR
 	for _, ip := range ips {
 		r := new(dns.A)
 		r.Hdr = dns.RR_Header{Name: zone, Rrtype: dns.TypeA,
-			Class: dns.ClassINET, Ttl: 3600}
+			Class: dns.ClassINET, Ttl: ttl}
 		r.A = ip
 		answers = append(answers, r)
 	}
 	return answers
 }
 
-func aaaa(zone string, ips []net.IP) []dns.RR {
+func aaaa(zone string, ips []net.IP, ttl uint32) []dns.RR {
 	var answers []dns.RR
 	for _, ip := range ips {
 		r := new(dns.AAAA)
 		r.Hdr = dns.RR_Header{Name: zone, Rrtype: dns.TypeAAAA,
-			Class: dns.ClassINET, Ttl: 3600}
+			Class: dns.ClassINET, Ttl: ttl}
 		r.AAAA = ip
 		answers = append(answers, r)
 	}
 	return answers
 }
 
-func nxdomain(zone string) []dns.RR {
-	s := fmt.Sprintf("%s 60 IN SOA ns1.%s postmaster.%s 1524370381 14400 3600 604800 60", zone, zone, zone)
+func cname(zone, target string, ttl uint32) []dns.RR {
+	r := new(dns.CNAME)
+	r.Hdr = dns.RR_Header{Name: zone, Rrtype: dns.TypeCNAME,
+		Class: dns.ClassINET, Ttl: ttl}
+	r.Target = dns.Fqdn(target)
+	return []dns.RR{r}
+}
+
+// nxdomain returns the SOA record for the authority section of a negative
+// response. Its TTL and minimum field determine how long resolvers cache the
+// response.
+func nxdomain(zone string, ttl uint32) []dns.RR {
+	s := fmt.Sprintf("%s %d IN SOA ns1.%s postmaster.%s 1524370381 14400 3600 604800 %d", zone, ttl, zone, zone, ttl)
 	soa, _ := dns.NewRR(s)
 	return []dns.RR{soa}
 }
@@ -98,6 +113,12 @@ func gzip(data []byte) ([]byte, error) {
 }
 
 func gunzip(data []byte) ([]byte, error) {
+	return gunzipWith(data, ioutil.ReadAll)
+}
+
+// gunzipWith decompresses data using read to consume the decompressed stream,
+// e.g. to limit its size.
+func gunzipWith(data []byte, read func(r io.Reader) ([]byte, error)) ([]byte, error) {
 	inputBuffer := bytes.NewReader(data)
 	compressionReader, err := gz.NewReader(inputBuffer)
 	if err != nil {
@@ -106,5 +127,40 @@ func gunzip(data []byte) ([]byte, error) {
 
 	defer compressionReader.Close()
 
-	return ioutil.ReadAll(compressionReader)
+	return read(compressionReader)
+}
+
+var byteSizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KB", 1 << 10},
+	{"MB", 1 << 20},
+	{"GB", 1 << 30},
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+	{"B", 1},
+}
+
+// parseByteSize parses sizes like "512", "100KB" or "64M". A size of zero
+// disables the limit.
+func parseByteSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSuffix(value, unit.suffix)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return n * multiplier, nil
 }
@@ -0,0 +1,121 @@
+/*
+ * Copyright 2018 - 2020 Christian Müller <dev@c-mueller.xyz>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ads
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// options lists all options of the ads block, it is used to suggest an
+// option for unknown ones
+var options = []string{
+	"default-lists", "strict-default-lists", "unfiltered-strict-default-lists",
+	"list", "list-catalog", "block-category", "permit-category",
+	"blacklist", "whitelist", "ip-blacklist", "block-ip",
+	"cname-cloaking", "cname-cloaking-list", "cname-cloak",
+	"http", "target", "target-ipv6", "startup-policy", "disable-fallback-list",
+	"disable-auto-update", "auto-update-interval", "file-poll-interval", "retry-count", "retry-interval",
+	"fetch-workers", "fetch-timeout", "max-list-size", "list-store", "log",
+	"block", "block-regex", "permit", "permit-regex", "inspect", "rebind-protection",
+	"block-page", "block-page-server", "ttl", "nxdomain", "policy-file", "listset", "use",
+}
+
+// suggestOption returns the option closest to the given unknown one, or an
+// empty string if none is similar enough.
+func suggestOption(name string) string {
+	suggestion, best := "", len(name)/3+1
+	for _, option := range options {
+		if d := editDistance(name, option); d <= best {
+			suggestion, best = option, d-1
+		}
+	}
+	return suggestion
+}
+
+// editDistance computes the Levenshtein distance of two strings.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+// checkWritable returns an error if the file cannot be written. Missing
+// files have to be creatable in their directory.
+func checkWritable(path string) error {
+	if info, err := os.Stat(path); err == nil {
+		if info.IsDir() {
+			return fmt.Errorf("%q is a directory", path)
+		}
+		file, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		return file.Close()
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), ".ads-")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// conflictingRules returns warnings for rules which are defined multiple
+// times and for names which are blocked and permitted.
+func (cfg *adsPluginConfig) conflictingRules() []string {
+	warnings := make([]string, 0)
+	conflicts := func(option string, rules []string, permitted map[string]bool) map[string]bool {
+		defined := make(map[string]bool)
+		for _, v := range rules {
+			if defined[v] {
+				warnings = append(warnings, fmt.Sprintf("The rule '%s %s' is defined multiple times", option, v))
+			} else if permitted[v] {
+				warnings = append(warnings, fmt.Sprintf("The rule '%s %s' is also permitted, permitting takes precedence", option, v))
+			}
+			defined[v] = true
+		}
+		return defined
+	}
+
+	conflicts("block", cfg.BlacklistRules, conflicts("permit", cfg.WhitelistRules, nil))
+	conflicts("block-regex", cfg.RegexBlacklistRules, conflicts("permit-regex", cfg.RegexWhitelistRules, nil))
+	return warnings
+}